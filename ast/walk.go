@@ -0,0 +1,88 @@
+package ast
+
+// Inspect traverses the AST in depth-first order, calling f for each node.
+// If f returns false, the children of that node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		inspectExpression(n.Value, f)
+	case *AssignStatement:
+		inspectExpression(n.Target, f)
+		inspectExpression(n.Value, f)
+	case *ReturnStatement:
+		inspectExpression(n.ReturnValue, f)
+	case *WhileStatement:
+		inspectExpression(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForStatement:
+		Inspect(n.Variable, f)
+		inspectExpression(n.Iterable, f)
+		Inspect(n.Body, f)
+	case *TryStatement:
+		Inspect(n.Block, f)
+		if n.Catch != nil {
+			Inspect(n.CatchParam, f)
+			Inspect(n.Catch, f)
+		}
+		if n.Finally != nil {
+			Inspect(n.Finally, f)
+		}
+	case *ThrowStatement:
+		inspectExpression(n.Value, f)
+	case *ExpressionStatement:
+		inspectExpression(n.Expression, f)
+	case *PrefixExpression:
+		inspectExpression(n.Right, f)
+	case *InfixExpression:
+		inspectExpression(n.Left, f)
+		inspectExpression(n.Right, f)
+	case *IfExpression:
+		inspectExpression(n.Condition, f)
+		Inspect(n.Consequence, f)
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+	case *CallExpression:
+		inspectExpression(n.Function, f)
+		for _, a := range n.Arguments {
+			inspectExpression(a, f)
+		}
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.Body, f)
+	case *ArrayLiteral:
+		for _, el := range n.Elements {
+			inspectExpression(el, f)
+		}
+	case *IndexExpression:
+		inspectExpression(n.Left, f)
+		inspectExpression(n.Index, f)
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			inspectExpression(pair.Key, f)
+			inspectExpression(pair.Value, f)
+		}
+	}
+}
+
+// inspectExpression guards against nil expressions left by parse errors.
+func inspectExpression(exp Expression, f func(Node) bool) {
+	if exp != nil {
+		Inspect(exp, f)
+	}
+}
@@ -1,6 +1,10 @@
 package code
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/kitasuke/monkey-go/token"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -87,3 +91,34 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTableLookup(t *testing.T) {
+	lines := LineTable{
+		{Offset: 0, Pos: token.Position{Line: 1, Column: 1}},
+		{Offset: 3, Pos: token.Position{Line: 2, Column: 5}},
+		{Offset: 7, Pos: token.Position{Line: 4, Column: 1}},
+	}
+
+	tests := []struct {
+		offset       int
+		expectedLine int
+	}{
+		{0, 1},
+		{2, 1},
+		{3, 2},
+		{6, 2},
+		{7, 4},
+		{100, 4},
+	}
+
+	for _, tt := range tests {
+		pos := lines.Lookup(tt.offset)
+		if pos.Line != tt.expectedLine {
+			t.Errorf("wrong line for offset %d. want=%d, got=%d", tt.offset, tt.expectedLine, pos.Line)
+		}
+	}
+
+	if pos := (LineTable{}).Lookup(0); pos.IsValid() {
+		t.Errorf("expected invalid position for empty table. got=%s", pos)
+	}
+}
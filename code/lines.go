@@ -0,0 +1,28 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/kitasuke/monkey-go/token"
+)
+
+// LineEntry maps the instructions starting at Offset to the source position
+// they were compiled from.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps instruction offsets to source positions. Entries are sorted
+// by offset, and each one applies up to the offset of the next.
+type LineTable []LineEntry
+
+// Lookup returns the source position of the instruction at offset, or an
+// invalid position if it is unknown.
+func (lt LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return lt[i-1].Pos
+}
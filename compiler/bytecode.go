@@ -0,0 +1,584 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/kitasuke/monkey-go/code"
+	"github.com/kitasuke/monkey-go/object"
+)
+
+// Bytecode files start with Magic followed by a big-endian uint16 format
+// version. The rest of the file is the constant pool, and the instruction
+// stream and line table of the main program.
+const (
+	Magic         = "\x7fMBC"
+	FormatVersion = 2
+)
+
+// FileExtension is the conventional extension of compiled Monkey files.
+const FileExtension = ".mbc"
+
+// tags identifying the type of an encoded constant
+const (
+	tagInteger          byte = 'i'
+	tagBigInteger       byte = 'I'
+	tagFloat            byte = 'f'
+	tagString           byte = 's'
+	tagCompiledFunction byte = 'F'
+)
+
+// MarshalBinary encodes the bytecode in the portable file format.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(Magic)
+	writeUint16(&buf, FormatVersion)
+
+	writeUvarint(&buf, uint64(len(b.Constants)))
+	for i, constant := range b.Constants {
+		err := writeConstant(&buf, constant)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	writeBytes(&buf, b.Instructions)
+	writeLines(&buf, b.Lines)
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes bytecode written by MarshalBinary and checks it
+// with Validate.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	r := &bytecodeReader{data: data}
+
+	magic := r.read(len(Magic))
+	if r.err != nil || string(magic) != Magic {
+		return fmt.Errorf("invalid bytecode: bad magic number")
+	}
+
+	version := r.readUint16()
+	if r.err != nil {
+		return fmt.Errorf("invalid bytecode: %s", r.err)
+	}
+	if version != FormatVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d", version, FormatVersion)
+	}
+
+	numConstants := r.readLength()
+	constants := []object.Object{}
+	for i := 0; i < numConstants && r.err == nil; i++ {
+		constant := r.readConstant()
+		if r.err != nil {
+			return fmt.Errorf("invalid bytecode: constant %d: %s", i, r.err)
+		}
+		constants = append(constants, constant)
+	}
+
+	instructions := code.Instructions(r.readBytes())
+	lines := r.readLines()
+	if r.err != nil {
+		return fmt.Errorf("invalid bytecode: %s", r.err)
+	}
+
+	if len(r.data) > 0 {
+		return fmt.Errorf("invalid bytecode: %d trailing bytes", len(r.data))
+	}
+
+	decoded := &Bytecode{Instructions: instructions, Constants: constants, Lines: lines}
+	err := decoded.Validate()
+	if err != nil {
+		return err
+	}
+
+	*b = *decoded
+	return nil
+}
+
+// Validate checks that the instructions of the program and of the functions
+// among its constants are consistent: their opcodes, constant and local
+// indexes, and jump targets are valid, they only pop values their frame
+// pushed, they only remove exception handlers their frame installed, and
+// functions return instead of running past their end. It does not check
+// free variables and builtins, which the VM checks as they are used.
+// Bytecode produced by the compiler is always valid.
+func (b *Bytecode) Validate() error {
+	for i, constant := range b.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		if fn.NumParameters > fn.NumLocals {
+			return fmt.Errorf("invalid bytecode: constant %d: function has %d parameters and %d locals", i, fn.NumParameters, fn.NumLocals)
+		}
+
+		err := validateInstructions(fn.Instructions, b.Constants, fn.NumLocals, true)
+		if err != nil {
+			return fmt.Errorf("invalid bytecode: constant %d: %s", i, err)
+		}
+	}
+
+	err := validateInstructions(b.Instructions, b.Constants, 0, false)
+	if err != nil {
+		return fmt.Errorf("invalid bytecode: %s", err)
+	}
+
+	return nil
+}
+
+func writeConstant(buf *bytes.Buffer, constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		buf.WriteByte(tagInteger)
+		writeVarint(buf, constant.Value)
+	case *object.BigInteger:
+		// the sign, then the magnitude in big-endian order
+		buf.WriteByte(tagBigInteger)
+		writeVarint(buf, int64(constant.Value.Sign()))
+		writeBytes(buf, constant.Value.Bytes())
+	case *object.Float:
+		buf.WriteByte(tagFloat)
+		writeUint64(buf, math.Float64bits(constant.Value))
+	case *object.String:
+		buf.WriteByte(tagString)
+		writeBytes(buf, []byte(constant.Value))
+	case *object.CompiledFunction:
+		buf.WriteByte(tagCompiledFunction)
+		writeUvarint(buf, uint64(constant.NumLocals))
+		writeUvarint(buf, uint64(constant.NumParameters))
+		writeBytes(buf, []byte(constant.Name))
+		writeBytes(buf, constant.Instructions)
+		writeLines(buf, constant.Lines)
+	default:
+		return fmt.Errorf("cannot encode %s", constant.Type())
+	}
+
+	return nil
+}
+
+// writeLines encodes a line table. The file name of an entry is only written
+// when it differs from the preceding entry's.
+func writeLines(buf *bytes.Buffer, lines code.LineTable) {
+	writeUvarint(buf, uint64(len(lines)))
+
+	filename := ""
+	for _, entry := range lines {
+		writeUvarint(buf, uint64(entry.Offset))
+		writeUvarint(buf, uint64(entry.Pos.Offset))
+		writeUvarint(buf, uint64(entry.Pos.Line))
+		writeUvarint(buf, uint64(entry.Pos.Column))
+
+		if entry.Pos.Filename == filename {
+			buf.WriteByte(0)
+		} else {
+			buf.WriteByte(1)
+			writeBytes(buf, []byte(entry.Pos.Filename))
+			filename = entry.Pos.Filename
+		}
+	}
+}
+
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// bytecodeReader consumes encoded data. The first error is kept in err and
+// turns all further reads into no-ops.
+type bytecodeReader struct {
+	data []byte
+	err  error
+}
+
+func (r *bytecodeReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *bytecodeReader) readByte() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *bytecodeReader) readUint16() uint16 {
+	b := r.read(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *bytecodeReader) readUint64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *bytecodeReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("malformed varint")
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *bytecodeReader) readVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("malformed varint")
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return v
+}
+
+// readLength reads a length prefix, which can never exceed the remaining
+// data.
+func (r *bytecodeReader) readLength() int {
+	n := r.readUvarint()
+	if r.err == nil && n > uint64(len(r.data)) {
+		r.err = fmt.Errorf("length %d exceeds remaining data", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *bytecodeReader) readBytes() []byte {
+	return r.read(r.readLength())
+}
+
+func (r *bytecodeReader) readLines() code.LineTable {
+	// every entry takes at least five bytes
+	n := r.readUvarint()
+	if r.err == nil && n > uint64(len(r.data)/5) {
+		r.err = fmt.Errorf("line table of %d entries exceeds remaining data", n)
+	}
+	if r.err != nil || n == 0 {
+		return nil
+	}
+
+	lines := make(code.LineTable, n)
+	filename := ""
+	for i := range lines {
+		lines[i].Offset = int(r.readUvarint())
+		lines[i].Pos.Offset = int(r.readUvarint())
+		lines[i].Pos.Line = int(r.readUvarint())
+		lines[i].Pos.Column = int(r.readUvarint())
+
+		if r.readByte() != 0 {
+			filename = string(r.readBytes())
+		}
+		lines[i].Pos.Filename = filename
+
+		if r.err == nil && i > 0 && lines[i].Offset < lines[i-1].Offset {
+			r.err = fmt.Errorf("line table is not sorted")
+		}
+	}
+
+	return lines
+}
+
+func (r *bytecodeReader) readConstant() object.Object {
+	switch tag := r.readByte(); tag {
+	case tagInteger:
+		return &object.Integer{Value: r.readVarint()}
+	case tagBigInteger:
+		sign := r.readVarint()
+		value := new(big.Int).SetBytes(r.readBytes())
+		if sign < 0 {
+			value.Neg(value)
+		}
+		return object.NewBigInteger(value)
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(r.readUint64())}
+	case tagString:
+		return &object.String{Value: string(r.readBytes())}
+	case tagCompiledFunction:
+		numLocals := r.readUvarint()
+		numParameters := r.readUvarint()
+		name := string(r.readBytes())
+		instructions := r.readBytes()
+		lines := r.readLines()
+
+		// locals are addressed by one-byte operands
+		if r.err == nil && (numLocals > 256 || numParameters > numLocals) {
+			r.err = fmt.Errorf("function has %d parameters and %d locals", numParameters, numLocals)
+		}
+
+		return &object.CompiledFunction{
+			Instructions:  instructions,
+			NumLocals:     int(numLocals),
+			NumParameters: int(numParameters),
+			Name:          name,
+			Lines:         lines,
+		}
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unknown constant tag %q", tag)
+		}
+		return nil
+	}
+}
+
+// validateInstructions checks that ins decodes into whole instructions with
+// known opcodes, that their operands refer to valid constants, to the
+// numLocals locals of the function and to the start of instructions, and
+// that they use the stack consistently.
+func validateInstructions(ins code.Instructions, constants []object.Object, numLocals int, function bool) error {
+	// offsets at which instructions start, and the end of ins
+	starts := map[int]bool{len(ins): true}
+	var jumps []int
+
+	for i := 0; i < len(ins); {
+		starts[i] = true
+
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			return fmt.Errorf("offset %d: %s", i, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return fmt.Errorf("offset %d: truncated %s", i, def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		switch code.Opcode(ins[i]) {
+		case code.OpConstant:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d out of range", i, operands[0])
+			}
+		case code.OpClosure:
+			if operands[0] >= len(constants) {
+				return fmt.Errorf("offset %d: constant %d out of range", i, operands[0])
+			}
+			if _, ok := constants[operands[0]].(*object.CompiledFunction); !ok {
+				return fmt.Errorf("offset %d: constant %d is not a function", i, operands[0])
+			}
+		case code.OpGetLocal, code.OpSetLocal, code.OpGetCell, code.OpSetCell, code.OpGetCellRef:
+			if operands[0] >= numLocals {
+				return fmt.Errorf("offset %d: local %d out of range", i, operands[0])
+			}
+		case code.OpHash:
+			if operands[0]%2 != 0 {
+				return fmt.Errorf("offset %d: odd number of hash elements %d", i, operands[0])
+			}
+		case code.OpJump, code.OpJumpNotTruthy, code.OpTry:
+			jumps = append(jumps, i)
+		}
+
+		i += 1 + read
+	}
+
+	for _, i := range jumps {
+		target := int(code.ReadUint16(ins[i+1:]))
+		if target > len(ins) {
+			return fmt.Errorf("offset %d: jump target %d out of range", i, target)
+		}
+		if !starts[target] {
+			return fmt.Errorf("offset %d: jump target %d is not an instruction", i, target)
+		}
+	}
+
+	return validateStack(ins, function)
+}
+
+// frameState is the state of a frame before an instruction runs.
+type frameState struct {
+	// number of values on the stack pushed by the frame
+	height int
+	// number of exception handlers installed by the frame
+	handlers int
+	// whether OpIterNext just ran, which pushes a value only if it also
+	// pushes true
+	iterNext bool
+}
+
+// validateStack follows every path through ins, which must be structurally
+// valid, checking that the paths meeting at an instruction agree on the
+// state of the frame.
+func validateStack(ins code.Instructions, function bool) error {
+	states := map[int]frameState{0: {}}
+	pending := []int{0}
+
+	// visit records the state in which the instruction at target runs
+	visit := func(target int, state frameState) error {
+		existing, ok := states[target]
+		if !ok {
+			states[target] = state
+			pending = append(pending, target)
+			return nil
+		}
+
+		if existing != state {
+			return fmt.Errorf("offset %d: stack height %d with %d handlers, but %d with %d handlers on another path",
+				target, state.height, state.handlers, existing.height, existing.handlers)
+		}
+		return nil
+	}
+
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		state := states[i]
+
+		if i == len(ins) {
+			if function {
+				return fmt.Errorf("offset %d: missing return at the end of the function", i)
+			}
+			continue
+		}
+
+		op := code.Opcode(ins[i])
+		def, _ := code.Lookup(ins[i])
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		if state.iterNext && op != code.OpJumpNotTruthy {
+			return fmt.Errorf("offset %d: %s after OpIterNext", i, def.Name)
+		}
+
+		pops, pushes := stackEffect(op, operands)
+		if state.height < pops {
+			return fmt.Errorf("offset %d: stack underflow in %s", i, def.Name)
+		}
+
+		next := frameState{height: state.height - pops + pushes, handlers: state.handlers}
+
+		var err error
+		switch op {
+		case code.OpJump:
+			err = visit(operands[0], next)
+		case code.OpJumpNotTruthy:
+			target := next
+			if state.iterNext {
+				// the iterator was exhausted and pushed only false
+				target.height--
+			}
+			err = visit(operands[0], target)
+			if err == nil {
+				err = visit(i+1+read, next)
+			}
+		case code.OpTry:
+			// the handler runs with the exception pushed
+			err = visit(operands[0], frameState{height: state.height + 1, handlers: state.handlers})
+			if err == nil {
+				next.handlers++
+				err = visit(i+1+read, next)
+			}
+		case code.OpEndTry:
+			if state.handlers == 0 {
+				return fmt.Errorf("offset %d: OpEndTry without OpTry", i)
+			}
+			next.handlers--
+			err = visit(i+1+read, next)
+		case code.OpIterNext:
+			next.iterNext = true
+			err = visit(i+1+read, next)
+		case code.OpReturn:
+			if !function {
+				return fmt.Errorf("offset %d: OpReturn outside a function", i)
+			}
+		case code.OpReturnValue, code.OpThrow:
+		default:
+			err = visit(i+1+read, next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stackEffect returns the number of values the instruction op with the
+// given operands pops from the stack and pushes onto it.
+func stackEffect(op code.Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal,
+		code.OpGetLocal, code.OpGetBuiltin, code.OpGetFree, code.OpGetCell,
+		code.OpGetCellRef, code.OpGetFreeRef, code.OpCurrentClosure:
+		return 0, 1
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal,
+		code.OpSetCell, code.OpSetFree, code.OpReturnValue, code.OpThrow:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual,
+		code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpBitNot, code.OpIter:
+		return 1, 1
+	case code.OpArray, code.OpHash, code.OpClosure:
+		return operands[len(operands)-1], 1
+	case code.OpCall:
+		return operands[0] + 1, 1
+	case code.OpIterNext:
+		return 1, 2
+	case code.OpSetIndex:
+		return 3, 0
+	case code.OpDupTwo:
+		return 2, 4
+	default:
+		return 0, 0
+	}
+}
@@ -0,0 +1,214 @@
+package compiler
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kitasuke/monkey-go/code"
+	"github.com/kitasuke/monkey-go/lexer"
+	"github.com/kitasuke/monkey-go/object"
+	"github.com/kitasuke/monkey-go/parser"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+	let greet = fn(name) { "héllo " + name };
+	let scale = fn(x) { x * 2.5 };
+	let counter = fn() { let n = -1; fn() { n += 1; n } };
+	greet("world");
+	scale(-1234567890123);
+	scale(99999999999999999999);
+	`
+
+	program := parser.New(lexer.NewWithFilename(input, "script.mk")).ParseProgram()
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	if len(bytecode.Lines) == 0 {
+		t.Fatalf("bytecode has no line table")
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	if !strings.HasPrefix(string(data), Magic) {
+		t.Fatalf("data does not start with magic number. got=%q", data[:len(Magic)])
+	}
+
+	decoded := &Bytecode{}
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary error: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("decoded bytecode differs.\nwant=%#v\ngot=%#v", bytecode, decoded)
+	}
+}
+
+func TestBytecodeUnmarshalErrors(t *testing.T) {
+	encode := func(b *Bytecode) []byte {
+		data, err := b.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error: %s", err)
+		}
+		return data
+	}
+
+	valid := encode(&Bytecode{
+		Instructions: code.Make(code.OpConstant, 0),
+		Constants:    []object.Object{&object.Integer{Value: 1}},
+	})
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[len(Magic)+1] = 99
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("\x7fMB"), "invalid bytecode: bad magic number"},
+		{[]byte("MONKEY"), "invalid bytecode: bad magic number"},
+		{badVersion, "unsupported bytecode version 99, want 2"},
+		{valid[:len(valid)-2], "invalid bytecode: length 3 exceeds remaining data"},
+		{append(valid, 0), "invalid bytecode: 1 trailing bytes"},
+		{
+			encode(&Bytecode{Instructions: code.Instructions{255}}),
+			"invalid bytecode: offset 0: opcode 255 undefined",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]}),
+			"invalid bytecode: offset 0: truncated OpConstant",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpConstant, 1), Constants: []object.Object{&object.Integer{Value: 1}}}),
+			"invalid bytecode: offset 0: constant 1 out of range",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpClosure, 0, 0), Constants: []object.Object{&object.Integer{Value: 1}}}),
+			"invalid bytecode: offset 0: constant 0 is not a function",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpJump, 10)}),
+			"invalid bytecode: offset 0: jump target 10 out of range",
+		},
+		{
+			encode(&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpJump, 4),
+				code.Make(code.OpConstant, 0),
+			}), Constants: []object.Object{&object.Integer{Value: 1}}}),
+			"invalid bytecode: offset 0: jump target 4 is not an instruction",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpGetLocal, 0)}),
+			"invalid bytecode: offset 0: local 0 out of range",
+		},
+		{
+			encode(&Bytecode{Constants: []object.Object{&object.CompiledFunction{
+				Instructions: code.Make(code.OpSetCell, 1),
+				NumLocals:    1,
+			}}}),
+			"invalid bytecode: constant 0: offset 0: local 1 out of range",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpPop)}),
+			"invalid bytecode: offset 0: stack underflow in OpPop",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpEndTry)}),
+			"invalid bytecode: offset 0: OpEndTry without OpTry",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpReturn)}),
+			"invalid bytecode: offset 0: OpReturn outside a function",
+		},
+		{
+			encode(&Bytecode{Instructions: code.Make(code.OpHash, 1)}),
+			"invalid bytecode: offset 0: odd number of hash elements 1",
+		},
+		{
+			encode(&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			})}),
+			"invalid bytecode: offset 5: stack height 1 with 0 handlers, but 0 with 0 handlers on another path",
+		},
+		{
+			encode(&Bytecode{Instructions: concatInstructions([]code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpIter),
+				code.Make(code.OpIterNext),
+				code.Make(code.OpPop),
+			})}),
+			"invalid bytecode: offset 5: OpPop after OpIterNext",
+		},
+		{
+			encode(&Bytecode{Constants: []object.Object{&object.CompiledFunction{
+				Instructions: code.Make(code.OpNull),
+			}}}),
+			"invalid bytecode: constant 0: offset 1: missing return at the end of the function",
+		},
+		{
+			encode(&Bytecode{Constants: []object.Object{&object.CompiledFunction{
+				Instructions:  code.Make(code.OpReturn),
+				NumParameters: 1,
+			}}}),
+			"invalid bytecode: constant 0: function has 1 parameters and 0 locals",
+		},
+	}
+
+	for i, tt := range tests {
+		err := (&Bytecode{}).UnmarshalBinary(tt.data)
+		if err == nil {
+			t.Errorf("tests[%d] - expected error but got none", i)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("tests[%d] - wrong error. want=%q, got=%q", i, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestBytecodeUnmarshalNormalizesBigIntegers(t *testing.T) {
+	data, err := (&Bytecode{Constants: []object.Object{&object.BigInteger{Value: big.NewInt(-5)}}}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %s", err)
+	}
+
+	decoded := &Bytecode{}
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatalf("UnmarshalBinary error: %s", err)
+	}
+
+	integer, ok := decoded.Constants[0].(*object.Integer)
+	if !ok || integer.Value != -5 {
+		t.Errorf("constant not decoded as Integer -5. got=%#v", decoded.Constants[0])
+	}
+}
+
+func TestBytecodeMarshalUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Boolean{Value: true}}}
+
+	_, err := bytecode.MarshalBinary()
+	if err == nil {
+		t.Fatalf("expected error but got none")
+	}
+
+	expected := "constant 0: cannot encode Boolean"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
@@ -2,13 +2,20 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
 
 	"github.com/kitasuke/monkey-go/ast"
 	"github.com/kitasuke/monkey-go/code"
 	"github.com/kitasuke/monkey-go/object"
+	"github.com/kitasuke/monkey-go/token"
 )
 
+var compoundAssignOpcodes = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
 type Compiler struct {
 	instructions        code.Instructions
 	constants           []object.Object
@@ -17,11 +24,15 @@ type Compiler struct {
 	symbolTable         *SymbolTable
 	scopes              []CompilationScope
 	scopeIndex          int
+
+	// position of the node being compiled
+	position token.Position
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	Lines        code.LineTable
 }
 
 type EmittedInstruction struct {
@@ -33,20 +44,50 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	loops               []*Loop
+	tries               []*Try
+	lines               code.LineTable
+
+	// number of operands of the expressions being compiled that are on the
+	// stack, waiting for the instruction that uses them
+	operands int
+}
+
+// Loop tracks the jump targets of the innermost loops being compiled.
+type Loop struct {
+	// position continue jumps back to
+	ContinuePos int
+	// positions of the break jumps to patch with the end of the loop
+	BreakPositions []int
+	// number of operands on the stack when the loop starts, which break
+	// and continue pop down to
+	Operands int
+}
+
+// Try tracks a try statement being compiled, whose exception handler must be
+// removed and whose finally block must run when return, break or continue
+// leave it.
+type Try struct {
+	// whether an exception handler is installed
+	Handler bool
+	Finally *ast.BlockStatement
+	// number of loops enclosing the statement
+	Loops int
 }
 
 func New() *Compiler {
+	return NewWithBuiltins(object.DefaultBuiltins())
+}
+
+// NewWithBuiltins returns a compiler for programs that run with builtins.
+func NewWithBuiltins(builtins *object.BuiltinRegistry) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
 
-	symbolTable := NewSymbolTable()
-
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	symbolTable := NewSymbolTableWithBuiltins(builtins)
 
 	return &Compiler{
 		constants:   []object.Object{},
@@ -57,15 +98,21 @@ func New() *Compiler {
 }
 
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
-	compiler := New()
+	compiler := NewWithBuiltins(nil)
 	compiler.symbolTable = s
 	compiler.constants = constants
 	return compiler
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	previousPosition := c.position
+	c.position = node.Pos()
+	defer func() { c.position = previousPosition }()
+
 	switch node := node.(type) {
 	case *ast.Program:
+		c.declareFunctions(node.Statements)
+
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 			if err != nil {
@@ -79,26 +126,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+
+		if node.Operator == "<" || node.Operator == "<=" {
+			err := c.compileOperands(node.Right, node.Left)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
 			}
-			c.emit(code.OpGreaterThan)
 			return nil
 		}
 
-		err := c.Compile(node.Left)
-		if err != nil {
-			return err
-		}
-
-		err = c.Compile(node.Right)
+		err := c.compileOperands(node.Left, node.Right)
 		if err != nil {
 			return err
 		}
@@ -112,8 +158,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
@@ -132,6 +192,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpBang)
 		case "-":
 			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
@@ -149,8 +211,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
+		if c.lastInstructionIs(code.OpPop) && endsWithExpression(node.Consequence) {
 			c.removeLastPop()
+		} else {
+			// the block does not end in an expression
+			c.emit(code.OpNull)
 		}
 
 		// Emit an `OpJump` with a bogus value
@@ -167,36 +232,112 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
+			if c.lastInstructionIs(code.OpPop) && endsWithExpression(node.Alternative) {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 
 		afterAlternative := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternative)
-	case *ast.IndexExpression:
-		err := c.Compile(node.Left)
+	case *ast.WhileStatement:
+		loop := c.enterLoop()
+
+		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		err = c.Compile(node.Index)
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		err = c.Compile(node.Body)
 		if err != nil {
 			return err
 		}
 
-		c.emit(code.OpIndex)
-	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+		c.emit(code.OpJump, loop.ContinuePos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+		c.leaveLoop(afterBodyPos)
+	case *ast.ForStatement:
+		err := c.Compile(node.Iterable)
 		if err != nil {
 			return err
 		}
 
+		c.emit(code.OpIter)
+		// the iterator lives in a hidden variable that cannot be named in
+		// source code, one per level of loop nesting
+		name := fmt.Sprintf("(iterator %d)", len(c.scopes[c.scopeIndex].loops))
+		iterator := c.symbolTable.Define(name)
+		c.storeSymbol(iterator)
+
+		loop := c.enterLoop()
+
+		c.loadSymbol(iterator)
+		c.emit(code.OpIterNext)
+
+		// Emit an `OpJumpNotTruthy` with a bogus value
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		variable := c.symbolTable.Define(node.Variable.Value)
+		c.storeSymbol(variable)
+
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loop.ContinuePos)
+
+		afterBodyPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterBodyPos)
+		c.leaveLoop(afterBodyPos)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside loop", node.Pos())
+		}
+
+		err := c.leaveLoopIteration(loop)
+		if err != nil {
+			return err
+		}
+
+		// Emit an `OpJump` with a bogus value
+		pos := c.emit(code.OpJump, 9999)
+		loop.BreakPositions = append(loop.BreakPositions, pos)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside loop", node.Pos())
+		}
+
+		err := c.leaveLoopIteration(loop)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpJump, loop.ContinuePos)
+	case *ast.IndexExpression:
+		err := c.compileOperands(node.Left, node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+	case *ast.CallExpression:
+		operands := []ast.Node{node.Function}
 		for _, a := range node.Arguments {
-			err := c.Compile(a)
-			if err != nil {
-				return err
-			}
+			operands = append(operands, a)
+		}
+
+		err := c.compileOperands(operands...)
+		if err != nil {
+			return err
 		}
 
 		c.emit(code.OpCall, len(node.Arguments))
@@ -214,28 +355,52 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+	case *ast.AssignStatement:
+		err := c.compileAssignStatement(node)
+		if err != nil {
+			return err
+		}
+	case *ast.TryStatement:
+		err := c.compileTryStatement(node)
+		if err != nil {
+			return err
+		}
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
 		}
+
+		c.emit(code.OpThrow)
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 
+		err = c.unwindTries(0)
+		if err != nil {
+			return err
+		}
+
 		c.emit(code.OpReturnValue)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 
 		c.loadSymbol(symbol)
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -246,48 +411,62 @@ func (c *Compiler) Compile(node ast.Node) error {
 		str := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(str))
 	case *ast.ArrayLiteral:
+		var operands []ast.Node
 		for _, el := range node.Elements {
-			err := c.Compile(el)
-			if err != nil {
-				return err
-			}
+			operands = append(operands, el)
+		}
+
+		err := c.compileOperands(operands...)
+		if err != nil {
+			return err
 		}
 
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+		var operands []ast.Node
+		for _, pair := range node.Pairs {
+			operands = append(operands, pair.Key, pair.Value)
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
 
-		for _, k := range keys {
-			err := c.Compile(k)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Pairs[k])
-			if err != nil {
-				return err
-			}
+		err := c.compileOperands(operands...)
+		if err != nil {
+			return err
 		}
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
 	case *ast.FunctionLiteral:
 		c.enterScope()
+		c.symbolTable.captured = capturedNames(node)
+		c.symbolTable.rebound = reboundNames(node)
+
+		// a local function refers to itself directly, unless its binding
+		// may change
+		outer := c.symbolTable.Outer
+		if node.Name != "" && outer.Outer != nil && !outer.rebound[node.Name] {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
 		}
 
+		// move captured parameters into cells
+		for _, p := range node.Parameters {
+			symbol, _ := c.symbolTable.Resolve(p.Value)
+			if symbol.Scope == CellScope {
+				c.emit(code.OpGetLocal, symbol.Index)
+				c.emit(code.OpSetCell, symbol.Index)
+			}
+		}
+
+		c.declareFunctions(node.Body.Statements)
+
 		err := c.Compile(node.Body)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
+		if c.lastInstructionIs(code.OpPop) && endsWithExpression(node.Body) {
 			c.replaceLastPopWithReturn()
 		}
 		if !c.lastInstructionIs(code.OpReturnValue) {
@@ -296,16 +475,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		lines := c.scopes[c.scopeIndex].lines
 		instructions := c.leaveScope()
 
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.loadCell(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+			Lines:         lines,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -315,10 +497,341 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileTryStatement compiles
+//
+//	try { B } catch (e) { C } finally { F }
+//
+// into
+//
+//	    OpTry catch
+//	    B
+//	    OpEndTry
+//	    F
+//	    OpJump end
+//	catch:
+//	    OpTry finally
+//	    e = exception
+//	    C
+//	    OpEndTry
+//	    F
+//	    OpJump end
+//	finally:
+//	    exception = exception
+//	    F
+//	    OpThrow exception
+//	end:
+//
+// leaving out the parts for a missing catch or finally block.
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	var exitPositions []int
+
+	// Emit an `OpTry` with a bogus value
+	tryPos := c.emit(code.OpTry, 9999)
+
+	c.enterTry(true, node.Finally)
+	err := c.Compile(node.Block)
+	if err != nil {
+		return err
+	}
+	c.leaveTry()
+
+	c.emit(code.OpEndTry)
+	err = c.compileFinally(node)
+	if err != nil {
+		return err
+	}
+
+	// Emit an `OpJump` with a bogus value
+	exitPositions = append(exitPositions, c.emit(code.OpJump, 9999))
+
+	if node.Catch != nil {
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+
+		if node.Finally != nil {
+			tryPos = c.emit(code.OpTry, 9999)
+			c.enterTry(true, node.Finally)
+		}
+
+		param := c.symbolTable.Define(node.CatchParam.Value)
+		c.storeSymbol(param)
+
+		err := c.Compile(node.Catch)
+		if err != nil {
+			return err
+		}
+
+		if node.Finally != nil {
+			c.leaveTry()
+
+			c.emit(code.OpEndTry)
+			err = c.compileFinally(node)
+			if err != nil {
+				return err
+			}
+
+			exitPositions = append(exitPositions, c.emit(code.OpJump, 9999))
+		}
+	}
+
+	if node.Finally != nil {
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+
+		// the exception lives in a hidden variable that cannot be named in
+		// source code while the finally block runs, one per level of try
+		// nesting
+		name := fmt.Sprintf("(exception %d)", len(c.scopes[c.scopeIndex].tries))
+		exception := c.symbolTable.Define(name)
+		c.storeSymbol(exception)
+
+		// try statements in the finally block are nested one level deeper
+		c.enterTry(false, nil)
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.leaveTry()
+
+		c.loadSymbol(exception)
+		c.emit(code.OpThrow)
+	}
+
+	afterTryPos := len(c.currentInstructions())
+	for _, pos := range exitPositions {
+		c.changeOperand(pos, afterTryPos)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileFinally(node *ast.TryStatement) error {
+	if node.Finally == nil {
+		return nil
+	}
+
+	return c.Compile(node.Finally)
+}
+
+func (c *Compiler) compileAssignStatement(node *ast.AssignStatement) error {
+	op, compound := compoundAssignOpcodes[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: undefined variable %s", target.Pos(), target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return fmt.Errorf("%s: cannot assign to builtin %s", target.Pos(), target.Value)
+		}
+
+		operands := 0
+		if compound {
+			c.loadSymbol(symbol)
+			operands = 1
+		}
+
+		err := c.compileWithOperands(operands, node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.compileOperands(target.Left, target.Index)
+		if err != nil {
+			return err
+		}
+
+		operands := 2
+		if compound {
+			c.emit(code.OpDupTwo)
+			c.emit(code.OpIndex)
+			operands = 3
+		}
+
+		err = c.compileWithOperands(operands, node.Value)
+		if err != nil {
+			return err
+		}
+
+		if compound {
+			c.emit(op)
+		}
+
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+
+	return nil
+}
+
+// capturedNames returns the names referenced inside the function literals
+// nested in fn. Locals of fn with these names are kept in cells, so that
+// closures share them with fn. This over-approximates the variables closures
+// actually capture, which is safe.
+func capturedNames(fn *ast.FunctionLiteral) map[string]bool {
+	names := map[string]bool{}
+
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		nested, ok := node.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+
+		ast.Inspect(nested, func(node ast.Node) bool {
+			if identifier, ok := node.(*ast.Identifier); ok {
+				names[identifier.Value] = true
+			}
+			return true
+		})
+
+		return false
+	})
+
+	return names
+}
+
+// endsWithExpression reports whether the last statement of block is an
+// expression statement, whose value a function returns implicitly and an if
+// expression evaluates to. Other statements, such as try, may also end in
+// an OpPop that must be kept.
+func endsWithExpression(block *ast.BlockStatement) bool {
+	n := len(block.Statements)
+	if n == 0 {
+		return false
+	}
+
+	_, ok := block.Statements[n-1].(*ast.ExpressionStatement)
+	return ok
+}
+
+// reboundNames returns the names that are assigned or bound more than once
+// anywhere in fn.
+func reboundNames(fn *ast.FunctionLiteral) map[string]bool {
+	names := map[string]bool{}
+	bound := map[string]bool{}
+
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			if bound[node.Name.Value] {
+				names[node.Name.Value] = true
+			}
+			bound[node.Name.Value] = true
+		case *ast.ForStatement:
+			names[node.Variable.Value] = true
+		case *ast.TryStatement:
+			if node.CatchParam != nil {
+				names[node.CatchParam.Value] = true
+			}
+		case *ast.AssignStatement:
+			if identifier, ok := node.Target.(*ast.Identifier); ok {
+				names[identifier.Value] = true
+			}
+		}
+		return true
+	})
+
+	return names
+}
+
+// compileLogicalExpression compiles && and || to jumps that skip the right
+// operand when the left one decides the result, which is true or false.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	// Emit jumps with bogus values
+	var jumpsToFalse, jumpsToEnd []int
+	if node.Operator == "&&" {
+		jumpsToFalse = append(jumpsToFalse, c.emit(code.OpJumpNotTruthy, 9999))
+	} else {
+		jumpRightPos := c.emit(code.OpJumpNotTruthy, 9999)
+		c.emit(code.OpTrue)
+		jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
+		c.changeOperand(jumpRightPos, len(c.currentInstructions()))
+	}
+
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+
+	jumpsToFalse = append(jumpsToFalse, c.emit(code.OpJumpNotTruthy, 9999))
+	c.emit(code.OpTrue)
+	jumpsToEnd = append(jumpsToEnd, c.emit(code.OpJump, 9999))
+
+	falsePos := c.emit(code.OpFalse)
+	for _, pos := range jumpsToFalse {
+		c.changeOperand(pos, falsePos)
+	}
+
+	afterPos := len(c.currentInstructions())
+	for _, pos := range jumpsToEnd {
+		c.changeOperand(pos, afterPos)
+	}
+
+	return nil
+}
+
+// declareFunctions defines the names of the functions bound by the let
+// statements in statements up front, so that functions can call functions
+// defined after them. A name that shadows an outer binding is left to its
+// let statement if it is used before, where it still means the outer one.
+func (c *Compiler) declareFunctions(statements []ast.Statement) {
+	for i, s := range statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		if _, ok := let.Value.(*ast.FunctionLiteral); !ok {
+			continue
+		}
+
+		name := let.Name.Value
+		if c.symbolTable.isDefined(name) && usesName(statements[:i], name) {
+			continue
+		}
+
+		c.symbolTable.Define(name)
+	}
+}
+
+// usesName reports whether statements refer to name outside of the function
+// literals they contain, whose bodies run later.
+func usesName(statements []ast.Statement, name string) bool {
+	used := false
+
+	for _, s := range statements {
+		ast.Inspect(s, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.FunctionLiteral:
+				return false
+			case *ast.Identifier:
+				if node.Value == name {
+					used = true
+				}
+			}
+			return !used
+		})
+	}
+
+	return used
+}
+
 func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
 	}
 }
 
@@ -332,10 +845,22 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
+	c.addLine(pos)
 
 	return pos
 }
 
+// addLine records the source position of the instruction at offset, unless
+// it is the same as the preceding instruction's.
+func (c *Compiler) addLine(offset int) {
+	lines := c.scopes[c.scopeIndex].lines
+	if len(lines) > 0 && lines[len(lines)-1].Pos == c.position {
+		return
+	}
+
+	c.scopes[c.scopeIndex].lines = append(lines, code.LineEntry{Offset: offset, Pos: c.position})
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
 	updatedInstructions := append(c.currentInstructions(), ins...)
@@ -374,6 +899,12 @@ func (c *Compiler) removeLastPop() {
 
 	c.scopes[c.scopeIndex].instructions = new
 	c.scopes[c.scopeIndex].lastInstruction = previous
+
+	lines := c.scopes[c.scopeIndex].lines
+	for len(lines) > 0 && lines[len(lines)-1].Offset >= len(new) {
+		lines = lines[:len(lines)-1]
+	}
+	c.scopes[c.scopeIndex].lines = lines
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -423,6 +954,137 @@ func (c *Compiler) replaceLastPopWithReturn() {
 	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
 }
 
+// compileOperands compiles nodes whose values stay on the stack for the
+// instruction that follows them.
+func (c *Compiler) compileOperands(nodes ...ast.Node) error {
+	for i, node := range nodes {
+		err := c.compileWithOperands(i, node)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileWithOperands compiles node while the given number of operands it
+// does not use are on the stack.
+func (c *Compiler) compileWithOperands(operands int, node ast.Node) error {
+	c.scopes[c.scopeIndex].operands += operands
+	err := c.Compile(node)
+	c.scopes[c.scopeIndex].operands -= operands
+
+	return err
+}
+
+func (c *Compiler) enterLoop() *Loop {
+	loop := &Loop{
+		ContinuePos: len(c.currentInstructions()),
+		Operands:    c.scopes[c.scopeIndex].operands,
+	}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+// leaveLoop patches the break jumps of the innermost loop to endPos.
+func (c *Compiler) leaveLoop(endPos int) {
+	loops := c.scopes[c.scopeIndex].loops
+	loop := loops[len(loops)-1]
+
+	for _, pos := range loop.BreakPositions {
+		c.changeOperand(pos, endPos)
+	}
+
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+func (c *Compiler) currentLoop() *Loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) enterTry(handler bool, finally *ast.BlockStatement) {
+	try := &Try{
+		Handler: handler,
+		Finally: finally,
+		Loops:   len(c.scopes[c.scopeIndex].loops),
+	}
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+}
+
+func (c *Compiler) leaveTry() {
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+}
+
+// leaveLoopIteration emits the code that leaves the current iteration of
+// loop for a break or continue: the try statements inside the loop are left
+// and the operands pushed since the loop started are popped.
+func (c *Compiler) leaveLoopIteration(loop *Loop) error {
+	err := c.unwindTries(len(c.scopes[c.scopeIndex].loops))
+	if err != nil {
+		return err
+	}
+
+	for i := loop.Operands; i < c.scopes[c.scopeIndex].operands; i++ {
+		c.emit(code.OpPop)
+	}
+
+	return nil
+}
+
+// unwindTries emits the code that leaves the try statements inside the
+// given number of loops, innermost first: their handlers are removed and
+// their finally blocks run.
+func (c *Compiler) unwindTries(loops int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+
+	for i := len(tries) - 1; i >= 0 && tries[i].Loops >= loops; i-- {
+		if tries[i].Handler {
+			c.emit(code.OpEndTry)
+		}
+
+		if tries[i].Finally != nil {
+			// the finally block runs outside of its try statement
+			c.scopes[c.scopeIndex].tries = tries[:i]
+
+			err := c.Compile(tries[i].Finally)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case CellScope:
+		c.emit(code.OpSetCell, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// loadCell pushes the cell of a variable captured by a closure.
+func (c *Compiler) loadCell(s Symbol) {
+	switch s.Scope {
+	case CellScope:
+		c.emit(code.OpGetCellRef, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFreeRef, s.Index)
+	}
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -433,5 +1095,9 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case CellScope:
+		c.emit(code.OpGetCell, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
@@ -1,5 +1,7 @@
 package compiler
 
+import "github.com/kitasuke/monkey-go/object"
+
 type SymbolScope string
 
 const (
@@ -7,6 +9,10 @@ const (
 	LocalScope   SymbolScope = "Local"
 	BuiltinScope SymbolScope = "Builtin"
 	FreeScope    SymbolScope = "Free"
+	// CellScope is a local variable captured by closures, stored in a cell
+	CellScope SymbolScope = "Cell"
+	// FunctionScope is the name of the function being compiled
+	FunctionScope SymbolScope = "Function"
 )
 
 type Symbol struct {
@@ -22,6 +28,14 @@ type SymbolTable struct {
 	numDefinitions int
 
 	FreeSymbols []Symbol
+
+	// names of locals that closures may capture
+	captured map[string]bool
+	// names of locals that are assigned or defined more than once
+	rebound map[string]bool
+
+	// builtins resolved by the outermost table
+	builtins *object.BuiltinRegistry
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -30,6 +44,14 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// NewSymbolTableWithBuiltins returns a global symbol table that resolves the
+// names of builtins, unless shadowed by globals.
+func NewSymbolTableWithBuiltins(builtins *object.BuiltinRegistry) *SymbolTable {
+	s := NewSymbolTable()
+	s.builtins = builtins
+	return s
+}
+
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s := NewSymbolTable()
 	s.Outer = outer
@@ -40,10 +62,17 @@ func (s *SymbolTable) Define(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
+	} else if s.captured[name] {
+		symbol.Scope = CellScope
 	} else {
 		symbol.Scope = LocalScope
 	}
 
+	// redefining a name in the same scope reuses its slot
+	if existing, ok := s.store[name]; ok && existing.Scope == symbol.Scope {
+		return existing
+	}
+
 	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
@@ -55,22 +84,63 @@ func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	return symbol
 }
 
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
-		obj, ok = s.Outer.Resolve(name)
-		if !ok {
-			return obj, ok
+		return s.resolveOuter(name)
+	}
+	if !ok && s.builtins != nil {
+		index, ok := s.builtins.Index(name)
+		return Symbol{Name: name, Scope: BuiltinScope, Index: index}, ok
+	}
+	return obj, ok
+}
+
+// isDefined reports whether name resolves in s or the scopes enclosing it.
+// Unlike Resolve, it does not capture name as a free variable.
+func (s *SymbolTable) isDefined(name string) bool {
+	for table := s; table != nil; table = table.Outer {
+		if _, ok := table.store[name]; ok {
+			return true
+		}
+		if table.builtins != nil {
+			if _, ok := table.builtins.Index(name); ok {
+				return true
+			}
 		}
+	}
 
-		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
-			return obj, ok
+	return false
+}
+
+// resolveOuter resolves name in the scopes enclosing s, skipping the
+// definitions of s itself.
+func (s *SymbolTable) resolveOuter(name string) (Symbol, bool) {
+	obj, ok := s.Outer.Resolve(name)
+	if ok && obj.Scope == FunctionScope {
+		// nested functions capture the binding of the function, not the
+		// function itself
+		if s.Outer.Outer == nil {
+			return Symbol{}, false
 		}
+		obj, ok = s.Outer.resolveOuter(name)
+	}
+	if !ok {
+		return obj, ok
+	}
 
-		free := s.defineFree(obj)
-		return free, true
+	if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		return obj, ok
 	}
-	return obj, ok
+
+	free := s.defineFree(obj)
+	return free, true
 }
 
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
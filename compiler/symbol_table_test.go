@@ -1,6 +1,10 @@
 package compiler
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/kitasuke/monkey-go/object"
+)
 
 func TestDefine(t *testing.T) {
 	expected := map[string]Symbol{
@@ -49,6 +53,34 @@ func TestDefine(t *testing.T) {
 	}
 }
 
+func TestRedefine(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	a := global.Define("a")
+	if a != (Symbol{"a", GlobalScope, 0}) {
+		t.Errorf("expected redefined a to reuse its slot, got=%+v", a)
+	}
+
+	if global.numDefinitions != 2 {
+		t.Errorf("wrong numDefinitions. got=%d, want=%d", global.numDefinitions, 2)
+	}
+
+	global.DefineBuiltin(0, "len")
+	len := global.Define("len")
+	if len != (Symbol{"len", GlobalScope, 2}) {
+		t.Errorf("expected len to shadow the builtin, got=%+v", len)
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	local.Resolve("a")
+	localA := local.Define("a")
+	if localA != (Symbol{"a", LocalScope, 0}) {
+		t.Errorf("expected a to be defined as local, got=%+v", localA)
+	}
+}
+
 func TestResolveGlobal(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -180,6 +212,48 @@ func TestDefineResolveBuiltins(t *testing.T) {
 	}
 }
 
+func TestResolveBuiltinRegistry(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	builtins.Register("len", &object.Builtin{})
+	builtins.Register("puts", &object.Builtin{})
+	builtins.Register("push", &object.Builtin{})
+	builtins.Remove("puts")
+
+	global := NewSymbolTableWithBuiltins(builtins)
+	global.Define("push")
+	local := NewEnclosedSymbolTable(global)
+
+	tests := []struct {
+		name     string
+		expected Symbol
+		ok       bool
+	}{
+		{"len", Symbol{Name: "len", Scope: BuiltinScope, Index: 0}, true},
+		{"puts", Symbol{}, false},
+		{"push", Symbol{Name: "push", Scope: GlobalScope, Index: 0}, true},
+		{"first", Symbol{}, false},
+	}
+
+	for _, table := range []*SymbolTable{global, local} {
+		for _, tt := range tests {
+			result, ok := table.Resolve(tt.name)
+			if ok != tt.ok {
+				t.Errorf("name %s resolvable=%t, want=%t", tt.name, ok, tt.ok)
+				continue
+			}
+			if ok && result != tt.expected {
+				t.Errorf("expected %s to resolve to %+v, got=%+v", tt.name, tt.expected, result)
+			}
+		}
+	}
+
+	// registering a builtin later makes it available
+	builtins.Register("first", &object.Builtin{})
+	if result, ok := local.Resolve("first"); !ok || result.Index != 3 {
+		t.Errorf("first not resolvable after registering it. got=%+v", result)
+	}
+}
+
 func TestResolveFree(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -292,3 +366,66 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := local.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+	local.DefineFunctionName("a")
+	local.Define("a")
+
+	expected := Symbol{Name: "a", Scope: LocalScope, Index: 0}
+
+	result, ok := local.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestResolveFunctionNameFromNestedScope(t *testing.T) {
+	global := NewSymbolTable()
+	outer := NewEnclosedSymbolTable(global)
+	outer.Define("a")
+
+	function := NewEnclosedSymbolTable(outer)
+	function.DefineFunctionName("a")
+
+	nested := NewEnclosedSymbolTable(function)
+
+	expected := Symbol{Name: "a", Scope: FreeScope, Index: 0}
+
+	result, ok := nested.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+
+	// the nested function captures the binding through the function
+	expectedFree := Symbol{Name: "a", Scope: LocalScope, Index: 0}
+	if len(function.FreeSymbols) != 1 || function.FreeSymbols[0] != expectedFree {
+		t.Errorf("wrong free symbols of function. got=%+v, want=%+v", function.FreeSymbols, expectedFree)
+	}
+}
@@ -1,7 +1,9 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/kitasuke/monkey-go/ast"
 	"github.com/kitasuke/monkey-go/object"
@@ -13,15 +15,72 @@ const (
 	typeMissMatchError      = "type mismatch"
 	identifierNotFoundError = "identifier not found"
 	notFunctionError        = "not a function"
+	notIterableError        = "not iterable"
 )
 
+// MaxCallDepth is the depth of nested calls, counting the program itself,
+// at which a call fails with a stack overflow, as vm.MaxFrames does in the
+// VM. It keeps runaway recursion from exhausting the Go stack, whatever the
+// Limits.
+const MaxCallDepth = 1024
+
 var (
-	Null  = &object.Null{}
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
+	Null     = object.NULL
+	True     = object.TRUE
+	False    = object.FALSE
+	Break    = &object.Break{}
+	Continue = &object.Continue{}
 )
 
+// EvalContext evaluates node until it finishes or ctx ends, within the
+// limits set on env. An evaluation that is stopped returns an error wrapping
+// object.ErrBudgetExceeded, which the program cannot catch.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment) (object.Object, error) {
+	budget := env.Budget()
+	budget.Start(ctx)
+	defer budget.Stop()
+
+	result := Eval(node, env)
+	if err, ok := result.(*object.Error); ok && err.Err != nil {
+		return result, err.Err
+	}
+
+	return result, nil
+}
+
+// CallContext calls fn with args from the top level of env, within the
+// limits set on env like EvalContext.
+func CallContext(ctx context.Context, fn object.Object, args []object.Object, env *object.Environment) (object.Object, error) {
+	budget := env.Budget()
+	budget.Start(ctx)
+	defer budget.Stop()
+
+	call := &object.Call{Caller: env, Depth: env.CallDepth() + 1}
+	result := applyFunction(fn, args, call)
+	if err, ok := result.(*object.Error); ok && err.Err != nil {
+		return result, err.Err
+	}
+
+	return result, nil
+}
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	err := env.Budget().Step()
+	if err != nil {
+		return budgetError(err)
+	}
+
+	result := eval(node, env)
+
+	// the innermost node an error comes from is where it was raised
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		err.Stack = env.StackTrace(node.Pos())
+	}
+
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -31,54 +90,76 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalBlockStatement(node, env)
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		if isControlFlow(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isControlFlow(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.AssignStatement:
+		return evalAssignStatement(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return Break
+	case *ast.ContinueStatement:
+		return Continue
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isControlFlow(val) {
+			return val
+		}
+		return throw(val)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isControlFlow(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isControlFlow(left) {
 			return left
 		}
+		if node.Operator == token.And || node.Operator == token.Or {
+			return evalLogicalExpression(node.Operator, left, node.Right, env)
+		}
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isControlFlow(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixWithinBudget(node.Operator, left, right, env)
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
-		if isError(function) {
+		if isControlFlow(function) {
 			return function
 		}
 
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isControlFlow(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		call := &object.Call{Pos: node.Pos(), Caller: env, Depth: env.CallDepth() + 1}
+		return applyFunction(function, args, call)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isControlFlow(left) {
 			return left
 		}
 
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isControlFlow(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
@@ -87,19 +168,24 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return allocate(env, &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name})
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInteger{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		if len(elements) == 1 && isControlFlow(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(env, &object.Array{Elements: elements})
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -124,27 +210,28 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
+// evalBlockStatement evaluates to the value of the last statement of block,
+// or null if the statement has none, as for a let statement.
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
 		result = Eval(statement, env)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.ReturnValueObj ||
-				rt == object.ErrorObj {
-				return result
-			}
+		if isControlFlow(result) {
+			return result
 		}
 	}
 
+	if result == nil {
+		return Null
+	}
 	return result
 }
 
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	if isControlFlow(condition) {
 		return condition
 	}
 
@@ -157,27 +244,273 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
+func evalAssignStatement(as *ast.AssignStatement, env *object.Environment) object.Object {
+	switch target := as.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if as.Operator != token.Assign {
+			current = evalIdentifier(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+
+		val := evalAssignedValue(as, current, env)
+		if isControlFlow(val) {
+			return val
+		}
+
+		if _, ok := env.Assign(target.Value, val); !ok {
+			return newError("%s: %s", identifierNotFoundError, target.Value)
+		}
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isControlFlow(left) {
+			return left
+		}
+
+		index := Eval(target.Index, env)
+		if isControlFlow(index) {
+			return index
+		}
+
+		var current object.Object
+		if as.Operator != token.Assign {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+
+		val := evalAssignedValue(as, current, env)
+		if isControlFlow(val) {
+			return val
+		}
+
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newError("cannot assign to %s", as.Target.String())
+	}
+
+	return Null
+}
+
+// evalAssignedValue evaluates the right-hand side of an assignment and, for
+// compound operators such as +=, combines it with the current value.
+func evalAssignedValue(as *ast.AssignStatement, current object.Object, env *object.Environment) object.Object {
+	val := Eval(as.Value, env)
+	if isControlFlow(val) || as.Operator == token.Assign {
+		return val
+	}
+
+	operator := strings.TrimSuffix(as.Operator, token.Assign)
+	return evalInfixWithinBudget(operator, current, val, env)
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch {
+	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
+		elements := left.(*object.Array).Elements
+		idx := index.(*object.Integer).Value
+
+		if idx < 0 || idx >= int64(len(elements)) {
+			return newError("index out of range: %d", idx)
+		}
+
+		elements[idx] = val
+	case left.Type() == object.HashObj:
+		key, ok := object.AsHashable(index)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+
+		left.(*object.Hash).Set(key, val)
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	return Null
+}
+
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(ws.Condition, env)
+		if isControlFlow(condition) {
+			return condition
+		}
+
+		if !isTruthy(condition) {
+			return Null
+		}
+
+		result := Eval(ws.Body, env)
+		if result == Break {
+			return Null
+		}
+		if isError(result) || result != nil && result.Type() == object.ReturnValueObj {
+			return result
+		}
+	}
+}
+
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isControlFlow(iterable) {
+		return iterable
+	}
+
+	elements, ok := iterableElements(iterable)
+	if !ok {
+		return newError("%s: %s", notIterableError, iterable.Type())
+	}
+
+	for _, element := range elements {
+		env.Set(fs.Variable.Value, element)
+
+		result := Eval(fs.Body, env)
+		if result == Break {
+			return Null
+		}
+		if isError(result) || result != nil && result.Type() == object.ReturnValueObj {
+			return result
+		}
+	}
+
+	return Null
+}
+
+// evalTryStatement evaluates the try block, handing an error it raises to
+// the catch block. The finally block always runs last; a return, break,
+// continue or error in it takes precedence over the outcome of the others.
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Block, env)
+	if isBudgetError(result) {
+		return result
+	}
+
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		env.Set(ts.CatchParam.Value, newException(err))
+		result = Eval(ts.Catch, env)
+		if isBudgetError(result) {
+			return result
+		}
+	}
+
+	if ts.Finally != nil {
+		if finally := Eval(ts.Finally, env); isControlFlow(finally) {
+			return finally
+		}
+	}
+
+	if isControlFlow(result) {
+		return result
+	}
+
+	return Null
+}
+
+// isControlFlow reports whether obj interrupts the evaluation of the
+// expressions and blocks around it rather than being a value.
+func isControlFlow(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+
+	switch obj.Type() {
+	case object.ReturnValueObj, object.ErrorObj, object.BreakObj, object.ContinueObj:
+		return true
+	default:
+		return false
+	}
+}
+
+// throw raises val. Throwing a caught exception raises it again unchanged.
+func throw(val object.Object) *object.Error {
+	if exception, ok := val.(*object.Exception); ok {
+		return &object.Error{Message: exception.Message, Value: exception.Value, Stack: exception.Stack}
+	}
+
+	return &object.Error{Message: object.ExceptionMessage(val), Value: val}
+}
+
+func newException(err *object.Error) *object.Exception {
+	value := err.Value
+	if value == nil {
+		value = &object.String{Value: err.Message}
+	}
+
+	return &object.Exception{Message: err.Message, Value: value, Stack: err.Stack}
+}
+
+// iterableElements returns the values a for loop visits: the elements of an
+// array, the characters of a string or the keys of a hash.
+func iterableElements(obj object.Object) ([]object.Object, bool) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return obj.Elements, true
+	case *object.String:
+		var elements []object.Object
+		for _, r := range obj.Value {
+			elements = append(elements, &object.String{Value: string(r)})
+		}
+		return elements, true
+	case *object.Hash:
+		var elements []object.Object
+		for _, pair := range obj.Pairs() {
+			elements = append(elements, pair.Key)
+		}
+		return elements, true
+	default:
+		return nil, false
+	}
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case token.Bang:
 		return evalBangOperatorExpression(right)
 	case token.Minus:
 		return evalMinusPrefixOperatorExpression(right)
+	case token.Tilde:
+		if !object.IsInteger(right) {
+			return newError("%s: %s%s", unknownOperatorError, operator, right.Type())
+		}
+		return object.ComplementInteger(right)
 	default:
 		return newError("%s: %s%s", unknownOperatorError, operator, right.Type())
 	}
 }
 
+// evalInfixWithinBudget evaluates an infix expression, accounting for the
+// objects it allocates and checking the budget before big integer
+// arithmetic, which may take long.
+func evalInfixWithinBudget(operator string, left, right object.Object, env *object.Environment) object.Object {
+	if left.Type() == object.BigIntegerObj || right.Type() == object.BigIntegerObj {
+		err := env.Budget().Check()
+		if err != nil {
+			return budgetError(err)
+		}
+	}
+
+	result := evalInfixExpression(operator, left, right)
+	if result.Type() == object.StringObj || result.Type() == object.BigIntegerObj {
+		return allocate(env, result)
+	}
+	return result
+}
+
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.IntegerObj && right.Type() == object.IntegerObj:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return evalIntegerInfixExpression(operator, left, right)
-	case left.Type() == object.StringObj && right.Type() == object.StringObj:
-		return evalStringInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	case operator == token.Equal:
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equal(left, right))
 	case operator == token.NotEqual:
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equal(left, right))
+	case left.Type() == object.StringObj && right.Type() == object.StringObj:
+		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("%s: %s %s %s", typeMissMatchError, left.Type(), operator, right.Type())
 	default:
@@ -185,12 +518,27 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	}
 }
 
+// evalLogicalExpression evaluates && and || to a boolean, evaluating right
+// only if left does not decide the result.
+func evalLogicalExpression(operator string, left object.Object, right ast.Expression, env *object.Environment) object.Object {
+	if isTruthy(left) == (operator == token.Or) {
+		return nativeBoolToBooleanObject(isTruthy(left))
+	}
+
+	result := Eval(right, env)
+	if isControlFlow(result) {
+		return result
+	}
+
+	return nativeBoolToBooleanObject(isTruthy(result))
+}
+
 func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isControlFlow(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -213,31 +561,65 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.IntegerObj {
+	switch right := right.(type) {
+	case *object.Integer, *object.BigInteger:
+		return object.NegateInteger(right)
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("%s: -%s", unknownOperatorError, right.Type())
 	}
-
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	switch operator {
+	case token.Plus, token.Minus, token.Asterisk, token.Slash, token.Percent,
+		token.Ampersand, token.Pipe, token.Caret, token.ShiftLeft, token.ShiftRight:
+		result, err := object.IntegerInfix(operator, left, right)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
+	case token.LessThan:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) < 0)
+	case token.GreaterThan:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) > 0)
+	case token.LessThanOrEqual:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) <= 0)
+	case token.GreaterThanOrEqual:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) >= 0)
+	case token.Equal:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) == 0)
+	case token.NotEqual:
+		return nativeBoolToBooleanObject(object.CompareIntegers(left, right) != 0)
+	default:
+		return newError("%s: %s %s %s", unknownOperatorError, left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates arithmetic and comparisons between two
+// numbers of which at least one is a float.
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
 
 	switch operator {
 	case token.Plus:
-		return &object.Integer{Value: leftValue + rightValue}
+		return &object.Float{Value: leftValue + rightValue}
 	case token.Minus:
-		return &object.Integer{Value: leftValue - rightValue}
+		return &object.Float{Value: leftValue - rightValue}
 	case token.Asterisk:
-		return &object.Integer{Value: leftValue * rightValue}
+		return &object.Float{Value: leftValue * rightValue}
 	case token.Slash:
-		return &object.Integer{Value: leftValue / rightValue}
+		return &object.Float{Value: leftValue / rightValue}
 	case token.LessThan:
 		return nativeBoolToBooleanObject(leftValue < rightValue)
 	case token.GreaterThan:
 		return nativeBoolToBooleanObject(leftValue > rightValue)
+	case token.LessThanOrEqual:
+		return nativeBoolToBooleanObject(leftValue <= rightValue)
+	case token.GreaterThanOrEqual:
+		return nativeBoolToBooleanObject(leftValue >= rightValue)
 	case token.Equal:
 		return nativeBoolToBooleanObject(leftValue == rightValue)
 	case token.NotEqual:
@@ -247,22 +629,42 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+// evalStringInfixExpression concatenates strings and orders them
+// lexicographically by their bytes.
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != token.Plus {
-		return newError("%s: %s %s %s", unknownOperatorError, left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case token.Plus:
+		return &object.String{Value: leftVal + rightVal}
+	case token.LessThan:
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case token.GreaterThan:
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case token.LessThanOrEqual:
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case token.GreaterThanOrEqual:
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newError("%s: %s %s %s", unknownOperatorError, left.Type(), operator, right.Type())
+	}
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		return evalStringIndexExpression(left, index)
 	case left.Type() == object.HashObj:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.ExceptionObj && index.Type() == object.StringObj:
+		field, ok := left.(*object.Exception).Field(index.(*object.String).Value)
+		if !ok {
+			return Null
+		}
+		return field
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
@@ -280,20 +682,32 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx := index.(*object.Integer).Value
+	max := int64(len(runes) - 1)
+
+	if idx < 0 || idx > max {
+		return Null
+	}
+
+	return &object.String{Value: string(runes[idx])}
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return Null
 	}
 
-	return pair.Value
+	return value
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
@@ -301,7 +715,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := env.Builtins().Lookup(node.Value); ok {
 		return builtin
 	}
 
@@ -309,40 +723,56 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash(len(node.Pairs))
 
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
+		if isControlFlow(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := Eval(pair.Value, env)
+		if isControlFlow(value) {
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return allocate(env, hash)
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func applyFunction(fn object.Object, args []object.Object, call *object.Call) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendedFunctionEnv(fn, args)
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d", len(fn.Parameters), len(args))
+		}
+
+		err := call.Caller.Budget().Call(call.Depth)
+		if err != nil {
+			return budgetError(err)
+		}
+
+		if call.Depth >= MaxCallDepth {
+			return newError("stack overflow")
+		}
+
+		call.Function = fn.Name
+		extendedEnv := extendedFunctionEnv(fn, args, call)
 		evaluated := Eval(fn.Body, extendedEnv)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
-			return result
+		if result := fn.Fn(call.Caller.BuiltinContext(), args...); result != nil {
+			if isError(result) {
+				return result
+			}
+			return allocate(call.Caller, result)
 		} else {
 			return Null
 		}
@@ -351,8 +781,8 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	}
 }
 
-func extendedFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+func extendedFunctionEnv(fn *object.Function, args []object.Object, call *object.Call) *object.Environment {
+	env := object.NewCallEnvironment(fn.Env, call)
 
 	for paramIdx, param := range fn.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -369,6 +799,21 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+func isNumber(obj object.Object) bool {
+	return object.IsInteger(obj) || obj.Type() == object.FloatObj
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger:
+		return object.IntegerToFloat(obj)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case Null:
@@ -394,6 +839,27 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// budgetError stops the evaluation with an error that cannot be caught.
+func budgetError(err error) *object.Error {
+	return &object.Error{Message: err.Error(), Err: err}
+}
+
+func isBudgetError(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && err.Err != nil
+}
+
+// allocate accounts for allocating obj, returning obj or the error of an
+// exceeded budget.
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	err := env.Budget().Allocate(obj)
+	if err != nil {
+		return budgetError(err)
+	}
+
+	return obj
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ErrorObj
@@ -1,8 +1,11 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/kitasuke/monkey-go/lexer"
 	"github.com/kitasuke/monkey-go/object"
@@ -29,6 +32,18 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 + 2 * 3 % 4", 3},
+		{"1 | 2 & 3", 3},
+		{"1 + 1 << 2", 5},
 	}
 
 	for _, tt := range tests {
@@ -37,6 +52,80 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalBigIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"1 << 64", "18446744073709551616"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"99999999999999999999 - 99999999999999999998", 1},
+		{"(1 << 64) / (1 << 60)", 16},
+		{"(1 << 64) % 7", 2},
+		{"(1 << 64) >> 64", 1},
+		{"~(1 << 64)", "-18446744073709551617"},
+		{"(1 << 64) | 1", "18446744073709551617"},
+		{"(1 << 64) > 1", true},
+		{"1 >= (1 << 64)", false},
+		{"(1 << 64) == 18446744073709551616", true},
+		{"(1 << 64) == 18446744073709551616.0", true},
+		{`
+		let factorial = fn(n) { if (n < 2) { 1 } else { n * factorial(n - 1) } };
+		factorial(25)
+		`, "15511210043330985984000000"},
+		{"let h = {18446744073709551616: 1}; h[1 << 64]", 1},
+		{"int(1e20)", "100000000000000000000"},
+		{"int(9223372036854775808.0)", "9223372036854775808"},
+		{"int(-9223372036854775808.0)", -9223372036854775808},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			result, ok := evaluated.(*object.BigInteger)
+			if !ok {
+				t.Errorf("object is not BigInteger. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if result.Inspect() != expected {
+				t.Errorf("object has wrong value. got=%s, want=%s", result.Inspect(), expected)
+			}
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"10 - 2.5", 7.5},
+		{"7 / 2.0", 3.5},
+		{"1e3 / 4", 250},
+		{`float(3)`, 3},
+		{`float("2.25")`, 2.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -45,6 +134,19 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"true", true},
 		{"false", false},
 		{"1 < 2", true},
+		{"1 <= 2", true},
+		{"2 <= 1", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"1 >= 1", true},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" > "B"`, true},
+		{`"abc" >= "abc"`, true},
+		{`"ab" <= "abc"`, true},
+		{`"" < "a"`, true},
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
@@ -58,6 +160,10 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"true != false", true},
 		{"false != true", true},
 		{"(1 < 2) == true", true},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"2.0 == 2", true},
+		{"0.1 + 0.2 != 0.3", true},
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
@@ -69,6 +175,39 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "b"`, true},
+		{`"1" == 1`, false},
+		{`1 == 1.0`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == {}`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{1: 1} == {"1": 1}`, false},
+		{`{2: 1} == {2.0: 1}`, true},
+		{`let n = if (false) { 1 }; n == n`, true},
+		{`let n = if (false) { 1 }; n == false`, false},
+		{`let n = if (false) { 1 }; n != 0`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b`, true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -113,6 +252,53 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 0", true},
+		{"(if (false) { 1 }) || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3 || 3 > 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!true || true", true},
+		{"let n = 0; let f = fn() { n = n + 1; true }; false && f(); n", 0},
+		{"let n = 0; let f = fn() { n = n + 1; true }; true || f(); n", 0},
+		{"let n = 0; let f = fn() { n = n + 1; true }; true && f() && f(); n", 2},
+		{"let n = 0; let f = fn() { n = n + 1; false }; f() || f() || true; n", 2},
+		{"[1][5] || 7", true},
+		{"false || 1 + true", fmt.Sprintf("%s: %s + %s", typeMissMatchError, object.IntegerObj, object.BooleanObj)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -147,6 +333,14 @@ func TestErrorHandling(t *testing.T) {
 		{"5 + true;", fmt.Sprintf("%s: %s + %s", typeMissMatchError, object.IntegerObj, object.BooleanObj)},
 		{"5 + true; 5;", fmt.Sprintf("%s: %s + %s", typeMissMatchError, object.IntegerObj, object.BooleanObj)},
 		{"-true", fmt.Sprintf("%s: -%s", unknownOperatorError, object.BooleanObj)},
+		{"~true", fmt.Sprintf("%s: ~%s", unknownOperatorError, object.BooleanObj)},
+		{"5 / 0", "division by zero"},
+		{"5 % (2 - 2)", "division by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"1 << 9999999", "shift count too large: 9999999"},
+		{"(1 << 1000000) * (1 << 1000000)", "integer too large: more than 1048576 bits"},
+		{"(1 << 64) / 0", "division by zero"},
+		{"1.5 % 1", fmt.Sprintf("%s: %s %% %s", unknownOperatorError, object.FloatObj, object.IntegerObj)},
 		{"true + false", fmt.Sprintf("%s: %s + %s", unknownOperatorError, object.BooleanObj, object.BooleanObj)},
 		{"5; true + false; 5", fmt.Sprintf("%s: %s + %s", unknownOperatorError, object.BooleanObj, object.BooleanObj)},
 		{"if (10 > 1) { true + false; }", fmt.Sprintf("%s: %s + %s", unknownOperatorError, object.BooleanObj, object.BooleanObj)},
@@ -159,7 +353,9 @@ func TestErrorHandling(t *testing.T) {
 		`, fmt.Sprintf("%s: %s + %s", unknownOperatorError, object.BooleanObj, object.BooleanObj)},
 		{"foobar", fmt.Sprintf("%s: %s", identifierNotFoundError, "foobar")},
 		{`"Hello" - "World"`, fmt.Sprintf("%s: %s - %s", unknownOperatorError, object.StringObj, object.StringObj)},
+		{`"a" <= 1`, fmt.Sprintf("%s: %s <= %s", typeMissMatchError, object.StringObj, object.IntegerObj)},
 		{`{"name": "Monky"}[fn(x) { x }];`, fmt.Sprintf("unusable as hash key: %s", object.FunctionObj)},
+		{`{[1, {}]: 1}`, fmt.Sprintf("unusable as hash key: %s", object.ArrayObj)},
 	}
 
 	for _, tt := range tests {
@@ -177,6 +373,189 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let r = 0; try { r = 1; } catch (e) { r = 2; }; r`, "1"},
+		{`let r = ""; try { throw "boom"; r = "unreachable"; } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let r = ""; try { 1 / 0; } catch (e) { r = e["message"]; }; r`, "division by zero"},
+		{`let r = 0; try { throw {"a": 7}; } catch (e) { r = e["value"]["a"]; }; r`, "7"},
+		{`let r = ""; try { len(1); } catch (e) { r = e["message"]; }; r`, `argument to "len" not supported, got Integer`},
+		{`let r = ""; try { 5 + true; } catch (e) { r = e["message"]; }; r`, "type mismatch: Integer + Boolean"},
+		{`let r = ""; try { foobar; } catch (e) { r = e["message"]; }; r`, "identifier not found: foobar"},
+		{`let r = []; try { throw 5; } catch (e) { r = push(r, e["value"]); } finally { r = push(r, 6); }; r`, "[5, 6]"},
+		{`let r = []; let f = fn() { try { return 1; } finally { r = push(r, 2); } }; let v = f(); push(r, v)`, "[2, 1]"},
+		{`let f = fn() { try { throw "a"; } finally { return 2; } }; f()`, "2"},
+		{`let f = fn() { try { return 1; } finally { return 2; } }; f()`, "2"},
+		{`let r = []; let i = 0; while (i < 3) { i += 1; try { if (i == 2) { continue; } r = push(r, i); } finally { r = push(r, 0); } }; r`, "[1, 0, 0, 3, 0]"},
+		{`let r = []; for (x in [1, 2, 3]) { try { if (x == 2) { break; } r = push(r, x); } finally { r = push(r, 0); } }; r`, "[1, 0, 0]"},
+		{`let r = ""; try { try { throw "inner"; } catch (e) { throw e; } } catch (e) { r = e["message"]; }; r`, "inner"},
+		{`let r = 0; try { try { throw "inner"; } finally { r = 1; } } catch (e) { r = r + 10; }; r`, "11"},
+		{`let g = fn(x) { if (x == 0) { throw "bottom"; } g(x - 1) + 1 }; let r = 0; try { g(3) } catch (e) { r = len(e["stack"]); }; r`, "5"},
+		{`let f = fn() { try { throw "x"; } catch (e) { return fn() { e["message"] }; } }; f()()`, "x"},
+		{`let x = 1; try { x = x + 1; throw x; } catch (err) { x = x + err["value"]; } finally { x = x * 10; }; x`, "40"},
+		{`let r = 0; try { throw 1; } catch (e) { r = e["missing"]; }; r`, "null"},
+		{`if (true) { try { 1 } catch (e) { 2 } }`, "null"},
+		{`if (false) { 1 } else { try { throw 1 } catch (e) { 2 } }`, "null"},
+		{`let f = fn() { try { 1 } finally { 2 } }; f()`, "null"},
+		{`let f = fn(x) { f(x + 1) }; let r = ""; try { f(0) } catch (e) { r = e["message"]; }; r`, "stack overflow"},
+		{`throw "oops";`, "ERROR: oops"},
+		{`try { throw "a"; } finally { 1; }`, "ERROR: a"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	evaluated := testEval(`let f = fn(x) { f(x + 1) + 1 }; f(0);`)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if errObj.Message != "stack overflow" {
+		t.Fatalf("wrong error message. want=%q, got=%q", "stack overflow", errObj.Message)
+	}
+
+	if len(errObj.Stack) != MaxCallDepth {
+		t.Errorf("wrong stack depth. want=%d, got=%d", MaxCallDepth, len(errObj.Stack))
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	input := `let f = fn() { throw "deep"; };
+let g = fn() { f() };
+g();`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "\tat f (1:16)\n\tat g (2:16)\n\tat <main> (3:1)\n"
+	if errObj.Stack.String() != expected {
+		t.Errorf("wrong stack trace. want=%q, got=%q", expected, errObj.Stack.String())
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; }; sum", 10},
+		{"let i = 0; while (true) { if (i > 3) { break; } let i = i + 1; }; i", 4},
+		{"let i = 0; let n = 0; while (i < 10) { let i = i + 1; if (i > 5) { continue; } let n = n + 1; }; n", 5},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let sum = sum + x; }; sum", 3},
+		{`let s = ""; for (c in "héllo") { let s = c + s; }; s`, "olléh"},
+		{"let sum = 0; for (k in {1: 10, 2: 20}) { let sum = sum + k; }; sum", 3},
+		{"let f = fn() { let i = 0; while (true) { let i = i + 1; if (i == 3) { return i * 10; } } }; f()", 30},
+		{"let n = 0; for (a in [1, 2]) { for (b in [1, 2, 3]) { if (b == 2) { break; } let n = n + 1; } }; n", 2},
+		{"let i = 0; while (true) { i += 1; let y = if (true) { break; }; }; i", 1},
+		{"let i = 0; let n = 0; while (i < 5) { i += 1; n += 1 + if (i > 2) { continue; } else { 0 }; }; n", 2},
+		{"let i = 0; while (i < 3000) { i += 1; let y = 1 + if (true) { continue; } else { 0 }; }; i", 3000},
+		{"let i = 0; while (i < 3) { i += 1; let b = 1 < if (true) { continue; } else { 2 }; }; i", 3},
+		{"let n = 0; for (x in [1, 2, 3]) { len([x, if (x == 2) { continue; }]); n += x; }; n", 4},
+		{"let n = 0; for (x in [1, 2, 3]) { let h = {x: if (x == 3) { break; } else { x }}; n += h[x]; }; n", 3},
+		{"let a = [0]; for (x in [1, 2, 3]) { a[0] += if (x == 2) { continue; } else { x }; }; a[0]", 4},
+		{"let n = 0; for (x in [1, 2]) { let m = 10 + if (true) { let k = 0; while (true) { k += 1; if (k == x) { break; } }; k }; n += m; }; n", 23},
+		{"let n = 0; for (x in [1, 2]) { n += 1 + if (true) { try { continue; } finally { n += 100; } } else { 0 }; }; n", 200},
+		{"let f = fn() { let y = 1 + if (true) { return 5; }; 10 }; f()", 5},
+		{"let f = fn() { while (false) { 1 } }; f()", nil},
+		{"if (true) { while (false) { } }", nil},
+		{"if (true) { for (a in []) { } }", nil},
+		{"let f = fn() { for (a in [1]) { break; } }; f()", nil},
+		{"if (true) { } else { 1 }", nil},
+		{"if (true) { let a = 1; }", nil},
+		{"fn() { }()", nil},
+		{"for (x in 5) { x }", "not iterable: Integer"},
+		{"while (1 + true) { 1 }", "type mismatch: Integer + Boolean"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			switch result := evaluated.(type) {
+			case *object.String:
+				if result.Value != expected {
+					t.Errorf("String has wrong value. want=%q, got=%q", expected, result.Value)
+				}
+			case *object.Error:
+				if result.Message != expected {
+					t.Errorf("wrong error message. expected=%q, got=%q", expected, result.Message)
+				}
+			default:
+				t.Errorf("object is not String or Error. got=%T (%+v)", evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a += 2; a;", 7},
+		{"let a = 5; a -= 2; a;", 3},
+		{"let a = 5; a *= 2; a;", 10},
+		{"let a = 6; a /= 2; a;", 3},
+		{"let i = 0; while (i < 5) { i += 1; }; i", 5},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1] + arr[0];", 6},
+		{"let arr = [1, 2, 3]; arr[2] *= 10; arr[2];", 30},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 5; h["a"] + h["b"];`, 8},
+		{"let count = 0; let inc = fn() { count += 1; }; inc(); inc(); count;", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c();", 3},
+		{"let f = fn(x) { let g = fn() { x = x * 2; }; g(); g(); x }; f(3)", 12},
+		{"let f = fn() { let a = 1; a = 2 }; f()", nil},
+		{"let f = fn() { let a = [1]; a[0] += 1 }; f()", nil},
+		{"let a = 1; if (true) { a = 2 }", nil},
+		{"b = 1;", "identifier not found: b"},
+		{"let arr = [1]; arr[5] = 1;", "index out of range: 5"},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: String"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -234,6 +613,100 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`
+			let wrapper = fn() {
+				let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };
+				sum(4)
+			};
+			wrapper();
+			`,
+			10,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+				isEven(10) + isOdd(7)
+			};
+			wrapper();
+			`,
+			2,
+		},
+		{
+			`
+			let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+			isEven(3) + isOdd(3)
+			`,
+			1,
+		},
+		{
+			`
+			let f = fn() { 1 };
+			let g = fn() { let h = f(); let f = fn() { 2 }; h + f() };
+			g();
+			`,
+			3,
+		},
+		{
+			`
+			let isOdd = 5;
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+				isEven(4)
+			};
+			wrapper();
+			`,
+			1,
+		},
+		{
+			`
+			let n = len([1, 2]);
+			let len = fn(x) { 10 };
+			n + len([1])
+			`,
+			12,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let countDown = fn(n) {
+					let next = fn() { countDown(n - 1) };
+					if (n == 0) { 7 } else { next() }
+				};
+				countDown(3)
+			};
+			wrapper();
+			`,
+			7,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } };
+				let g = f;
+				f = fn(n) { 42 };
+				g(3)
+			};
+			wrapper();
+			`,
+			42,
+		},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -270,8 +743,15 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("h\u00e9llo")`, 5},
+		{`len("日本語")`, 3},
 		{`len(1)`, fmt.Sprintf("argument to %q not supported, got %s", object.BuiltinFuncNameLen, object.IntegerObj)},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`int(3.99)`, 3},
+		{`int(-2.5)`, -2},
+		{`int("42")`, 42},
+		{`int("x")`, `could not parse "x" as integer`},
+		{`int([])`, fmt.Sprintf("argument to %q not supported, got %s", object.BuiltinFuncNameInt, object.ArrayObj)},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, nil},
 		{`first(1)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameFirst, object.ArrayObj, object.IntegerObj)},
@@ -288,6 +768,11 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`push(1, 2)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNamePush, object.ArrayObj, object.IntegerObj)},
 		{`push(1)`, "wrong number of arguments. got=1, want=2"},
 		{`puts("hello", "world!")`, nil},
+		{`keys({3: 1, 1: 2, 2: 3})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3})`, []int{1, 2, 3}},
+		{`entries({3: 1, 1: 2})[1]`, []int{1, 2}},
+		{`let h = {1: 1, 2: 2}; h[1] = 3; h[3] = 4; keys(h)`, []int{1, 2, 3}},
+		{`values(1)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameValues, object.HashObj, object.IntegerObj)},
 	}
 
 	for _, tt := range tests {
@@ -403,6 +888,39 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`let s = "a\tb"; s[1]`, "\t"},
+		{`"héllo"[5]`, nil},
+		{`"abc"[-1]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		str, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+
+		result, ok := evaluated.(*object.String)
+		if !ok {
+			t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if result.Value != str {
+			t.Errorf("String has wrong value. want=%q, got=%q", str, result.Value)
+		}
+	}
+}
+
 func TestHashLiterals(t *testing.T) {
 	input := `
 		let two = "two";
@@ -431,16 +949,21 @@ func TestHashLiterals(t *testing.T) {
 		False.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for i, pair := range result.Pairs() {
+		expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 		if !ok {
 			t.Errorf("no pair for given key in pairs")
 		}
 
+		// pairs are in insertion order
+		if expectedValue != int64(i+1) {
+			t.Errorf("pair %d has wrong key %s", i, pair.Key.Inspect())
+		}
+
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
@@ -470,6 +993,10 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{5: 5}[5]`,
 			5,
 		},
+		{
+			`{5: 5}[5.0]`,
+			5,
+		},
 		{
 			`{true: 5}[true]`,
 			5,
@@ -478,6 +1005,22 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{[1, 2]: 5}[[1, 2]]`,
+			5,
+		},
+		{
+			`{[1, 2]: 5}[[2, 1]]`,
+			nil,
+		},
+		{
+			`{[1, ["a"]]: 5}[[1, ["a"]]]`,
+			5,
+		},
+		{
+			`let k = [1]; let h = {k: 5}; k[0] = 2; h[[1]]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
@@ -515,6 +1058,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -537,3 +1095,117 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	}
 	return true
 }
+
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{`while (true) { }`, object.Limits{MaxInstructions: 1000}, "instruction limit exceeded"},
+		{`let i = 0; while (true) { try { i += 1; } catch (e) { } }`, object.Limits{MaxInstructions: 1000}, "instruction limit exceeded"},
+		{`let f = fn(n) { f(n + 1) }; f(0)`, object.Limits{MaxCallDepth: 100}, "call depth limit exceeded"},
+		{`let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { 1 }`, object.Limits{MaxCallDepth: 100}, "call depth limit exceeded"},
+		{`let f = fn() { try { f() } finally { return 1 } }; f()`, object.Limits{MaxCallDepth: 100}, "call depth limit exceeded"},
+		{`let a = []; while (true) { a = push(a, 1) }`, object.Limits{MaxAllocations: 10000}, "allocation limit exceeded"},
+		{`let s = ""; while (true) { s = s + "x" }`, object.Limits{MaxAllocations: 100}, "allocation limit exceeded"},
+		{`while (true) { }`, object.Limits{Timeout: time.Millisecond}, "time limit exceeded"},
+		{`let x = 3; while (true) { x = x * x }`, object.Limits{MaxAllocations: 1000}, "allocation limit exceeded"},
+		{`let x = 3; while (true) { x *= x }`, object.Limits{MaxAllocations: 1000}, "allocation limit exceeded"},
+		{`let x = (1 << 500000) + 1; while (true) { x = x * x / x }`, object.Limits{Timeout: time.Millisecond}, "time limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		env.SetLimits(tt.limits)
+
+		_, err := EvalContext(context.Background(), program, env)
+		if !errors.Is(err, object.ErrBudgetExceeded) {
+			t.Errorf("error is not ErrBudgetExceeded. got=%T (%+v)", err, err)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestEvalContextCancelled(t *testing.T) {
+	program := parser.New(lexer.New(`while (true) { }`)).ParseProgram()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := EvalContext(ctx, program, object.NewEnvironment())
+
+	if !errors.Is(err, object.ErrBudgetExceeded) {
+		t.Errorf("error is not ErrBudgetExceeded. got=%T (%+v)", err, err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error is not context.DeadlineExceeded. got=%T (%+v)", err, err)
+	}
+}
+
+func TestEvalWithinBudget(t *testing.T) {
+	env := object.NewEnvironment()
+	env.SetLimits(object.Limits{MaxInstructions: 1000, MaxCallDepth: 11, MaxAllocations: 100})
+
+	program := parser.New(lexer.New(`let f = fn(n) { if (n == 0) { return 0 } n + f(n - 1) };`)).ParseProgram()
+	_, err := EvalContext(context.Background(), program, env)
+	if err != nil {
+		t.Fatalf("EvalContext failed: %s", err)
+	}
+
+	// every evaluation gets a new budget
+	program = parser.New(lexer.New(`f(10)`)).ParseProgram()
+	for i := 0; i < 3; i++ {
+		evaluated, err := EvalContext(context.Background(), program, env)
+		if err != nil {
+			t.Fatalf("EvalContext failed: %s", err)
+		}
+
+		testIntegerObject(t, evaluated, 55)
+	}
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	builtins := object.DefaultBuiltins()
+	builtins.Remove("puts")
+	builtins.Register("double", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`double(len("abc"))`, 6},
+		{`puts("hello")`, "identifier not found: puts"},
+		{`let double = fn(x) { x }; double(1)`, 1},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.SetBuiltins(builtins)
+
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, env)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
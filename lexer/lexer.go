@@ -1,26 +1,69 @@
 package lexer
 
 import (
+	"strings"
+	"unicode/utf8"
+
 	"github.com/kitasuke/monkey-go/token"
 )
 
+// Mode controls optional lexer behaviour.
+type Mode uint
+
+const (
+	// ScanComments makes the lexer return comments as token.Comment
+	// instead of skipping them.
+	ScanComments Mode = 1 << iota
+)
+
 type Lexer struct {
 	input        string
+	filename     string
+	mode         Mode
 	position     int
 	nextPosition int
 	ch           byte
+
+	// line and column of ch
+	line   int
+	column int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+func NewWithFilename(input string, filename string) *Lexer {
+	l := New(input)
+	l.filename = filename
+	return l
+}
+
+func NewWithMode(input string, mode Mode) *Lexer {
+	l := New(input)
+	l.mode = mode
+	return l
+}
+
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
 
-	l.skipWhitespace()
+		pos := l.currentPosition()
+		tok := l.readToken()
+		tok.Pos = pos
+		tok.End = l.currentPosition()
+
+		if tok.Type != token.Comment || l.mode&ScanComments != 0 {
+			return tok
+		}
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -33,9 +76,17 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.Assign, l.ch)
 		}
 	case '+':
-		tok = newToken(token.Plus, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.PlusAssign)
+		} else {
+			tok = newToken(token.Plus, l.ch)
+		}
 	case '-':
-		tok = newToken(token.Minus, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.MinusAssign)
+		} else {
+			tok = newToken(token.Minus, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -46,13 +97,61 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.Bang, l.ch)
 		}
 	case '*':
-		tok = newToken(token.Asterisk, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.AsteriskAssign)
+		} else {
+			tok = newToken(token.Asterisk, l.ch)
+		}
 	case '/':
-		tok = newToken(token.Slash, l.ch)
+		switch l.peekChar() {
+		case '/':
+			tok.Type = token.Comment
+			tok.Literal = l.readLineComment()
+			return tok
+		case '*':
+			tok.Literal, tok.Type = l.readBlockComment()
+			return tok
+		case '=':
+			tok = l.readTwoCharToken(token.SlashAssign)
+		default:
+			tok = newToken(token.Slash, l.ch)
+		}
 	case '<':
-		tok = newToken(token.LessThan, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.LessThanOrEqual)
+		case '<':
+			tok = l.readTwoCharToken(token.ShiftLeft)
+		default:
+			tok = newToken(token.LessThan, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GreaterThan, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.GreaterThanOrEqual)
+		case '>':
+			tok = l.readTwoCharToken(token.ShiftRight)
+		default:
+			tok = newToken(token.GreaterThan, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.And)
+		} else {
+			tok = newToken(token.Ampersand, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.Or)
+		} else {
+			tok = newToken(token.Pipe, l.ch)
+		}
+	case '%':
+		tok = newToken(token.Percent, l.ch)
+	case '^':
+		tok = newToken(token.Caret, l.ch)
+	case '~':
+		tok = newToken(token.Tilde, l.ch)
 	case ',':
 		tok = newToken(token.Comma, l.ch)
 	case ';':
@@ -72,8 +171,15 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = newToken(token.RightBracket, l.ch)
 	case '"':
-		tok.Type = token.String
-		tok.Literal = l.readString()
+		pos := l.position
+		value, ok := l.readString()
+		if ok {
+			tok.Type = token.String
+			tok.Literal = value
+		} else {
+			tok.Type = token.Illegal
+			tok.Literal = l.input[pos:l.position]
+		}
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
@@ -83,8 +189,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdentifierType(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.Int
+			tok.Literal, tok.Type = l.readNumber()
 			return tok
 		} else {
 			tok = newToken(token.Illegal, l.ch)
@@ -99,29 +204,215 @@ func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
+// readTwoCharToken reads a token made of ch and the following character.
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 func (l *Lexer) readChar() {
+	if l.nextPosition > len(l.input) {
+		// already at EOF
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
 	l.ch = l.peekChar()
 	l.position = l.nextPosition
 	l.nextPosition += 1
+	l.column++
 }
 
-func (l *Lexer) readString() string {
-	pos := l.position + 1
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// readString reads a double-quoted string literal and decodes its escape
+// sequences. ok is false if the literal is not terminated before EOF.
+func (l *Lexer) readString() (value string, ok bool) {
+	var out strings.Builder
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+
+		switch l.ch {
+		case '"':
+			return out.String(), true
+		case 0:
+			return out.String(), false
+		case '\\':
+			l.readEscape(&out)
+		default:
+			out.WriteByte(l.ch)
 		}
 	}
+}
+
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+// readEscape decodes the escape sequence starting at the backslash in ch.
+// Supported are the single character escapes in escapes, \xHH, \uHHHH and
+// \u{H...}. Unknown or malformed escapes are kept verbatim.
+func (l *Lexer) readEscape(out *strings.Builder) {
+	if l.peekChar() == 0 {
+		// unterminated, left to readString
+		return
+	}
+
+	l.readChar()
+
+	if ch, ok := escapes[l.ch]; ok {
+		out.WriteByte(ch)
+		return
+	}
+
+	switch l.ch {
+	case 'x':
+		if r, ok := l.readHexEscape(2); ok {
+			out.WriteByte(byte(r))
+			return
+		}
+	case 'u':
+		if l.peekChar() == '{' {
+			if r, ok := l.readBracedHexEscape(); ok {
+				out.WriteRune(r)
+				return
+			}
+		} else if r, ok := l.readHexEscape(4); ok {
+			out.WriteRune(r)
+			return
+		}
+	}
+
+	out.WriteByte('\\')
+	out.WriteByte(l.ch)
+}
+
+// readHexEscape reads exactly n hex digits following ch. Nothing is
+// consumed unless all n digits are present.
+func (l *Lexer) readHexEscape(n int) (rune, bool) {
+	var r rune
+	for i := 1; i <= n; i++ {
+		d, ok := hexValue(l.peekCharAt(i))
+		if !ok {
+			return 0, false
+		}
+		r = r<<4 | d
+	}
+
+	for i := 0; i < n; i++ {
+		l.readChar()
+	}
+	return r, true
+}
+
+// readBracedHexEscape reads a {H...} code point following ch. Nothing is
+// consumed unless the code point is well-formed.
+func (l *Lexer) readBracedHexEscape() (rune, bool) {
+	var r rune
+	i := 2
+	for ; l.peekCharAt(i) != '}'; i++ {
+		d, ok := hexValue(l.peekCharAt(i))
+		if !ok || r > utf8.MaxRune {
+			return 0, false
+		}
+		r = r<<4 | d
+	}
+
+	if i == 2 || r > utf8.MaxRune {
+		return 0, false
+	}
+
+	for ; i > 0; i-- {
+		l.readChar()
+	}
+	return r, true
+}
+
+func (l *Lexer) readLineComment() string {
+	pos := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
 	return l.input[pos:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readBlockComment reads a /* ... */ comment. An unterminated comment is
+// returned as token.Illegal.
+func (l *Lexer) readBlockComment() (string, token.TokenType) {
 	pos := l.position
+
+	// skip the opening /*
+	l.readChar()
+	l.readChar()
+
+	for {
+		if l.ch == 0 {
+			return l.input[pos:l.position], token.Illegal
+		}
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return l.input[pos:l.position], token.Comment
+		}
+		l.readChar()
+	}
+}
+
+// readNumber reads an integer or a floating-point literal such as 3.14 or
+// 1e-9.
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	pos := l.position
+	var tokenType token.TokenType = token.Int
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.Float
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		offset := 1
+		if sign := l.peekCharAt(offset); sign == '+' || sign == '-' {
+			offset++
+		}
+
+		if isDigit(l.peekCharAt(offset)) {
+			tokenType = token.Float
+			for i := 0; i < offset; i++ {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return l.input[pos:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
 }
 
 func (l *Lexer) readIdentifier() string {
@@ -140,6 +431,19 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func hexValue(ch byte) (rune, bool) {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return rune(ch - '0'), true
+	case 'a' <= ch && ch <= 'f':
+		return rune(ch - 'a' + 10), true
+	case 'A' <= ch && ch <= 'F':
+		return rune(ch - 'A' + 10), true
+	default:
+		return 0, false
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -153,3 +457,11 @@ func (l *Lexer) peekChar() byte {
 		return l.input[l.nextPosition]
 	}
 }
+
+// peekCharAt returns the character offset bytes ahead of ch.
+func (l *Lexer) peekCharAt(offset int) byte {
+	if l.position+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.position+offset]
+}
@@ -16,7 +16,7 @@ func TestNextToken(t *testing.T) {
 		};
 
 		let result = add(five, ten);
-		!-/*5;
+		!-/ *5;
 		5 < 10 > 5;
 
 		if (5 < 10) {
@@ -26,6 +26,9 @@ func TestNextToken(t *testing.T) {
 		}
 		10 == 10;
 		10 != 9;
+		1 <= 2 >= 1;
+		a && b || c;
+		5 % 2 & 3 | 4 ^ ~1 << 2 >> 1;
 		"foobar"
 		"foo bar"
 		[1, 2];
@@ -109,6 +112,33 @@ func TestNextToken(t *testing.T) {
 		{token.NotEqual, "!="},
 		{token.Int, "9"},
 		{token.Semicolon, ";"},
+		{token.Int, "1"},
+		{token.LessThanOrEqual, "<="},
+		{token.Int, "2"},
+		{token.GreaterThanOrEqual, ">="},
+		{token.Int, "1"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "a"},
+		{token.And, "&&"},
+		{token.Identifier, "b"},
+		{token.Or, "||"},
+		{token.Identifier, "c"},
+		{token.Semicolon, ";"},
+		{token.Int, "5"},
+		{token.Percent, "%"},
+		{token.Int, "2"},
+		{token.Ampersand, "&"},
+		{token.Int, "3"},
+		{token.Pipe, "|"},
+		{token.Int, "4"},
+		{token.Caret, "^"},
+		{token.Tilde, "~"},
+		{token.Int, "1"},
+		{token.ShiftLeft, "<<"},
+		{token.Int, "2"},
+		{token.ShiftRight, ">>"},
+		{token.Int, "1"},
+		{token.Semicolon, ";"},
 		{token.String, "foobar"},
 		{token.String, "foo bar"},
 		{token.LeftBracket, "["},
@@ -138,3 +168,211 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"ab\" +\nfoo"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.Let, token.Position{Filename: "test.mk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "test.mk", Offset: 3, Line: 1, Column: 4}},
+		{token.Identifier, token.Position{Filename: "test.mk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "test.mk", Offset: 5, Line: 1, Column: 6}},
+		{token.Assign, token.Position{Filename: "test.mk", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "test.mk", Offset: 7, Line: 1, Column: 8}},
+		{token.Int, token.Position{Filename: "test.mk", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "test.mk", Offset: 9, Line: 1, Column: 10}},
+		{token.Semicolon, token.Position{Filename: "test.mk", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "test.mk", Offset: 10, Line: 1, Column: 11}},
+		{token.String, token.Position{Filename: "test.mk", Offset: 13, Line: 2, Column: 3}, token.Position{Filename: "test.mk", Offset: 17, Line: 2, Column: 7}},
+		{token.Plus, token.Position{Filename: "test.mk", Offset: 18, Line: 2, Column: 8}, token.Position{Filename: "test.mk", Offset: 19, Line: 2, Column: 9}},
+		{token.Identifier, token.Position{Filename: "test.mk", Offset: 20, Line: 3, Column: 1}, token.Position{Filename: "test.mk", Offset: 23, Line: 3, Column: 4}},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 23, Line: 3, Column: 4}, token.Position{Filename: "test.mk", Offset: 23, Line: 3, Column: 4}},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 23, Line: 3, Column: 4}, token.Position{Filename: "test.mk", Offset: 23, Line: 3, Column: 4}},
+	}
+
+	l := NewWithFilename(input, "test.mk")
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 10 / 2; // trailing comment
+/* block
+   comment */ x /* inline */ * 2
+/* unterminated`
+
+	tests := []struct {
+		mode            Mode
+		expectedType    []token.TokenType
+		expectedLiteral []string
+	}{
+		{
+			0,
+			[]token.TokenType{
+				token.Let, token.Identifier, token.Assign, token.Int, token.Slash, token.Int, token.Semicolon,
+				token.Identifier, token.Asterisk, token.Int, token.Illegal, token.EOF,
+			},
+			[]string{
+				"let", "x", "=", "10", "/", "2", ";",
+				"x", "*", "2", "/* unterminated", "",
+			},
+		},
+		{
+			ScanComments,
+			[]token.TokenType{
+				token.Comment,
+				token.Let, token.Identifier, token.Assign, token.Int, token.Slash, token.Int, token.Semicolon, token.Comment,
+				token.Comment, token.Identifier, token.Comment, token.Asterisk, token.Int, token.Illegal, token.EOF,
+			},
+			[]string{
+				"// leading comment",
+				"let", "x", "=", "10", "/", "2", ";", "// trailing comment",
+				"/* block\n   comment */", "x", "/* inline */", "*", "2", "/* unterminated", "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		l := NewWithMode(input, tt.mode)
+
+		for i, expectedType := range tt.expectedType {
+			tok := l.NextToken()
+			if tok.Type != expectedType {
+				t.Fatalf("mode %d tests[%d] - tokenType wrong. expected=%q, got=%q", tt.mode, i, expectedType, tok.Type)
+			}
+
+			if tok.Literal != tt.expectedLiteral[i] {
+				t.Fatalf("mode %d tests[%d] - literal wrong. expected=%q, got=%q", tt.mode, i, tt.expectedLiteral[i], tok.Literal)
+			}
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 1e-9 2E+10 7e3 1. 1.x 3e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.Int, "5"},
+		{token.Float, "3.14"},
+		{token.Float, "0.5"},
+		{token.Float, "1e-9"},
+		{token.Float, "2E+10"},
+		{token.Float, "7e3"},
+		{token.Int, "1"},
+		{token.Illegal, "."},
+		{token.Int, "1"},
+		{token.Illegal, "."},
+		{token.Identifier, "x"},
+		{token.Int, "3"},
+		{token.Identifier, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"hello"`, token.String, "hello"},
+		{`"a\nb\tc\r"`, token.String, "a\nb\tc\r"},
+		{`"say \"hi\" \\ bye"`, token.String, `say "hi" \ bye`},
+		{`"\x41é\u{1F600}"`, token.String, "Aé😀"},
+		{`"héllo"`, token.String, "héllo"},
+		{`"\q\x4"`, token.String, `\q\x4`},
+		{`"\u{110000}"`, token.String, `\u{110000}`},
+		{`"unterminated`, token.Illegal, `"unterminated`},
+		{`"ends with \"`, token.Illegal, `"ends with \"`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Fatalf("tests[%d] - expected EOF, got=%q", i, tok.Type)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.Identifier, "x"},
+		{token.Assign, "="},
+		{token.Int, "1"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "x"},
+		{token.PlusAssign, "+="},
+		{token.Int, "2"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "x"},
+		{token.MinusAssign, "-="},
+		{token.Int, "3"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "x"},
+		{token.AsteriskAssign, "*="},
+		{token.Int, "4"},
+		{token.Semicolon, ";"},
+		{token.Identifier, "x"},
+		{token.SlashAssign, "/="},
+		{token.Int, "5"},
+		{token.Semicolon, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
@@ -1,20 +1,312 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 
+	"github.com/kitasuke/monkey-go/ast"
+	"github.com/kitasuke/monkey-go/compiler"
+	"github.com/kitasuke/monkey-go/evaluator"
+	"github.com/kitasuke/monkey-go/lexer"
+	"github.com/kitasuke/monkey-go/object"
+	"github.com/kitasuke/monkey-go/parser"
 	"github.com/kitasuke/monkey-go/repl"
+	"github.com/kitasuke/monkey-go/vm"
 )
 
+// exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `Usage: monkey <command> [arguments]
+
+Commands:
+  run [--engine=vm|eval] <file>     run a source file (.mk) or bytecode file (.mbc)
+  repl                              start an interactive session
+  compile [-o <output>] <file>      compile a source file to bytecode
+  disasm <file>                     print the instructions of a source or bytecode file
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "run":
+		return runCommand(args, stdin, stdout, stderr)
+	case "repl":
+		return replCommand(args, stdin, stdout, stderr)
+	case "compile":
+		return compileCommand(args, stderr)
+	case "disasm":
+		return disasmCommand(args, stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "monkey: unknown command %q\n\n%s", command, usage)
+		return exitUsage
+	}
+}
+
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("run", stderr)
+	engine := flags.String("engine", "vm", "use 'vm' or 'eval'")
+	file, ok := parseFileArgs(flags, args)
+	if !ok {
+		return exitUsage
+	}
+
+	if *engine != "vm" && *engine != "eval" {
+		fmt.Fprintf(stderr, "monkey run: unknown engine %q\n", *engine)
+		return exitUsage
+	}
+
+	context := object.NewBuiltinContext(stdin, stdout, stderr)
+
+	if isBytecodeFile(file) {
+		if *engine != "vm" {
+			fmt.Fprintf(stderr, "monkey run: bytecode files require the vm engine\n")
+			return exitUsage
+		}
+
+		bytecode, err := readBytecode(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return exitError
+		}
+
+		return runBytecode(bytecode, context)
+	}
+
+	program, ok := parseFile(file, stderr)
+	if !ok {
+		return exitError
+	}
+
+	if *engine == "eval" {
+		env := object.NewEnvironment()
+		env.SetBuiltinContext(context)
+		result := evaluator.Eval(program, env)
+		if errObj, ok := result.(*object.Error); ok {
+			fmt.Fprintf(stderr, "runtime error: %s\n", errObj.Message)
+			io.WriteString(stderr, errObj.Stack.String())
+			return exitError
+		}
+		return exitOK
+	}
+
+	bytecode, ok := compileProgram(program, stderr)
+	if !ok {
+		return exitError
+	}
+
+	return runBytecode(bytecode, context)
+}
+
+func replCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("repl", stderr)
+	err := flags.Parse(args)
+	if err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
 	user, err := user.Current()
+	if err == nil {
+		fmt.Fprintf(stdout, "Hello %s! This is the Monkey programming language!\n", user.Username)
+	}
+	fmt.Fprintf(stdout, "Feel free to type in commands\n")
+
+	repl.Start(stdin, stdout)
+	return exitOK
+}
+
+func compileCommand(args []string, stderr io.Writer) int {
+	flags := newFlagSet("compile", stderr)
+	output := flags.String("o", "", "write the bytecode to `file` (default: the input with the "+compiler.FileExtension+" extension)")
+	file, ok := parseFileArgs(flags, args)
+	if !ok {
+		return exitUsage
+	}
+
+	program, ok := parseFile(file, stderr)
+	if !ok {
+		return exitError
+	}
+
+	bytecode, ok := compileProgram(program, stderr)
+	if !ok {
+		return exitError
+	}
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", file, err)
+		return exitError
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(file, filepath.Ext(file)) + compiler.FileExtension
+	}
+
+	err = os.WriteFile(*output, data, 0644)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+func disasmCommand(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("disasm", stderr)
+	file, ok := parseFileArgs(flags, args)
+	if !ok {
+		return exitUsage
+	}
+
+	var bytecode *compiler.Bytecode
+	if isBytecodeFile(file) {
+		var err error
+		bytecode, err = readBytecode(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return exitError
+		}
+	} else {
+		program, ok := parseFile(file, stderr)
+		if !ok {
+			return exitError
+		}
+
+		bytecode, ok = compileProgram(program, stderr)
+		if !ok {
+			return exitError
+		}
+	}
+
+	fmt.Fprintf(stdout, "main:\n%s", bytecode.Instructions)
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(stdout, "\nconstant %d: locals=%d parameters=%d\n%s",
+			i, fn.NumLocals, fn.NumParameters, fn.Instructions)
+	}
+
+	return exitOK
+}
+
+func newFlagSet(command string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage of monkey %s:\n", command)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFileArgs parses the flags of a command that takes a single file.
+func parseFileArgs(flags *flag.FlagSet, args []string) (string, bool) {
+	err := flags.Parse(args)
+	if err != nil {
+		return "", false
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(flags.Output(), "monkey %s: expected exactly one file\n", flags.Name())
+		return "", false
+	}
+
+	return flags.Arg(0), true
+}
+
+func isBytecodeFile(file string) bool {
+	return filepath.Ext(file) == compiler.FileExtension
+}
+
+func parseFile(file string, stderr io.Writer) (*ast.Program, bool) {
+	input, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s\n", err)
+		return nil, false
+	}
+
+	l := lexer.NewWithFilename(string(input), file)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+		return nil, false
+	}
+
+	return program, true
+}
+
+func compileProgram(program *ast.Program, stderr io.Writer) (*compiler.Bytecode, bool) {
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(stderr, "compiler error: %s\n", err)
+		return nil, false
+	}
+
+	return comp.Bytecode(), true
+}
+
+func readBytecode(file string) (*compiler.Bytecode, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := &compiler.Bytecode{}
+	err = bytecode.UnmarshalBinary(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return bytecode, nil
+}
+
+func runBytecode(bytecode *compiler.Bytecode, context *object.BuiltinContext) int {
+	stderr := context.Stderr
+
+	machine := vm.New(bytecode)
+	machine.SetBuiltinContext(context)
+	err := machine.Run()
 	if err != nil {
-		print(err)
+		fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(stderr, runtimeErr.StackTrace())
+		}
+		return exitError
 	}
 
-	fmt.Printf("Hello %s! This is the Monky programming language!\n", user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	return exitOK
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, input string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	err := os.WriteFile(file, []byte(input), 0644)
+	if err != nil {
+		t.Fatalf("could not write script: %s", err)
+	}
+	return file
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ok := writeScript(t, dir, "ok.mk", "let x = 1; x + 2;")
+	syntaxError := writeScript(t, dir, "syntax.mk", "let = 1;")
+	compileError := writeScript(t, dir, "compile.mk", "y;")
+	runtimeError := writeScript(t, dir, "runtime.mk", "1 + true;")
+
+	tests := []struct {
+		args           []string
+		expectedCode   int
+		expectedStderr string
+	}{
+		{[]string{}, exitUsage, "Usage: monkey"},
+		{[]string{"unknown"}, exitUsage, `unknown command "unknown"`},
+		{[]string{"run"}, exitUsage, "expected exactly one file"},
+		{[]string{"run", "--engine=js", ok}, exitUsage, `unknown engine "js"`},
+		{[]string{"run", ok}, exitOK, ""},
+		{[]string{"run", "--engine=eval", ok}, exitOK, ""},
+		{[]string{"run", syntaxError}, exitError, syntaxError + ":1:5: expected next token to be Identifier, got = instead"},
+		{[]string{"run", compileError}, exitError, "compiler error: " + compileError + ":1:1: undefined variable y"},
+		{[]string{"run", "--engine=eval", compileError}, exitError, "runtime error: identifier not found: y"},
+		{[]string{"run", runtimeError}, exitError, "runtime error: unsupported types for binary operation: Integer Boolean"},
+		{[]string{"run", "--engine=eval", runtimeError}, exitError, "runtime error: type mismatch: Integer + Boolean"},
+		{[]string{"run", filepath.Join(dir, "missing.mk")}, exitError, "no such file"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+
+		if code != tt.expectedCode {
+			t.Errorf("%v: wrong exit code. want=%d, got=%d (stderr=%q)", tt.args, tt.expectedCode, code, stderr.String())
+		}
+
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q", tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestCompileAndDisasm(t *testing.T) {
+	dir, err := os.MkdirTemp("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := writeScript(t, dir, "add.mk", "let add = fn(a, b) { a + b }; add(1, 2);")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"compile", source}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("compile failed with code %d: %s", code, stderr.String())
+	}
+
+	bytecodeFile := filepath.Join(dir, "add.mbc")
+	if _, err := os.Stat(bytecodeFile); err != nil {
+		t.Fatalf("bytecode file not written: %s", err)
+	}
+
+	code = run([]string{"run", bytecodeFile}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("running bytecode failed with code %d: %s", code, stderr.String())
+	}
+
+	expected := `main:
+0000 OpClosure 0 0
+0004 OpSetGlobal 0
+0007 OpGetGlobal 0
+0010 OpConstant 1
+0013 OpConstant 2
+0016 OpCall 2
+0018 OpPop
+
+constant 0: locals=2 parameters=2
+0000 OpGetLocal 0
+0002 OpGetLocal 1
+0004 OpAdd
+0005 OpReturnValue
+`
+
+	for _, file := range []string{source, bytecodeFile} {
+		stdout.Reset()
+		code = run([]string{"disasm", file}, strings.NewReader(""), &stdout, &stderr)
+		if code != exitOK {
+			t.Fatalf("disasm failed with code %d: %s", code, stderr.String())
+		}
+
+		if stdout.String() != expected {
+			t.Errorf("wrong disassembly of %s.\nwant=%q\ngot=%q", file, expected, stdout.String())
+		}
+	}
+
+	corrupt := writeScript(t, dir, "corrupt.mbc", "garbage")
+	stderr.Reset()
+	code = run([]string{"run", corrupt}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code for corrupt bytecode. want=%d, got=%d", exitError, code)
+	}
+	if !strings.Contains(stderr.String(), "bad magic number") {
+		t.Errorf("wrong error for corrupt bytecode. got=%q", stderr.String())
+	}
+}
+
+func TestRunUsesStandardStreams(t *testing.T) {
+	dir, err := os.MkdirTemp("", "monkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := writeScript(t, dir, "greet.mk", `
+		let name = read_line("name? ");
+		printf("hello %s, you are %d\n", name, int(read_line()));
+		puts(read_line());
+	`)
+
+	for _, engine := range []string{"--engine=vm", "--engine=eval"} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"run", engine, script}, strings.NewReader("monkey\n42\n"), &stdout, &stderr)
+
+		if code != exitOK {
+			t.Fatalf("%s: wrong exit code. want=%d, got=%d (stderr=%q)", engine, exitOK, code, stderr.String())
+		}
+
+		expected := "name? hello monkey, you are 42\nnull\n"
+		if stdout.String() != expected {
+			t.Errorf("%s: wrong stdout. want=%q, got=%q", engine, expected, stdout.String())
+		}
+	}
+}
+
+func TestRepl(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"repl"}, strings.NewReader("// note\n1 + 2\n"), &stdout, &stderr)
+
+	if code != exitOK {
+		t.Fatalf("wrong exit code. want=%d, got=%d (stderr=%q)", exitOK, code, stderr.String())
+	}
+
+	expected := ">> >> 3\n>> "
+	if !strings.HasSuffix(stdout.String(), expected) {
+		t.Errorf("wrong stdout. want=%q, got=%q", expected, stdout.String())
+	}
+}
@@ -0,0 +1,275 @@
+// Package monkey embeds the Monkey programming language in Go programs.
+//
+// An Interpreter keeps its global variables between calls to Eval, so a
+// host can load a script once and then call its functions:
+//
+//	interp := monkey.New()
+//	interp.Register("greeting", func(args ...object.Object) (object.Object, error) {
+//		return &object.String{Value: "hello"}, nil
+//	})
+//	_, err := interp.Eval(`let greet = fn(name) { greeting() + ", " + name };`)
+//	result, err := interp.Call("greet", &object.String{Value: "monkey"})
+//
+// Booleans and null passed to an Interpreter must be object.TRUE,
+// object.FALSE and object.NULL. An Interpreter is not safe for concurrent
+// use.
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kitasuke/monkey-go/ast"
+	"github.com/kitasuke/monkey-go/compiler"
+	"github.com/kitasuke/monkey-go/evaluator"
+	"github.com/kitasuke/monkey-go/lexer"
+	"github.com/kitasuke/monkey-go/object"
+	"github.com/kitasuke/monkey-go/parser"
+	"github.com/kitasuke/monkey-go/vm"
+)
+
+// Engine selects how an Interpreter executes programs.
+type Engine int
+
+const (
+	// EngineVM compiles programs to bytecode and runs them on the VM.
+	EngineVM Engine = iota
+	// EngineEval walks the syntax tree with the evaluator.
+	EngineEval
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineVM:
+		return "vm"
+	case EngineEval:
+		return "eval"
+	default:
+		return fmt.Sprintf("Engine(%d)", int(e))
+	}
+}
+
+// Func is a Go function that Monkey programs can call. A returned error is
+// raised as an exception in the program, and a nil result becomes null.
+type Func func(args ...object.Object) (object.Object, error)
+
+// RuntimeError is returned for an error the program did not catch, by
+// either engine.
+type RuntimeError = vm.RuntimeError
+
+// ParseErrors lists the syntax errors of a program.
+type ParseErrors []*parser.Error
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Interpreter runs Monkey programs that share their global variables.
+type Interpreter struct {
+	engine   Engine
+	limits   object.Limits
+	builtins *object.BuiltinRegistry
+	context  *object.BuiltinContext
+
+	// state of the VM engine
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+
+	// state of the evaluator engine
+	env *object.Environment
+}
+
+// New returns an Interpreter using the VM.
+func New() *Interpreter {
+	return NewWithEngine(EngineVM)
+}
+
+func NewWithEngine(engine Engine) *Interpreter {
+	return NewWithBuiltins(engine, object.DefaultBuiltins())
+}
+
+// NewWithBuiltins returns an Interpreter whose programs can only call the
+// given builtins. Later changes to the registry apply to later programs.
+func NewWithBuiltins(engine Engine, builtins *object.BuiltinRegistry) *Interpreter {
+	i := &Interpreter{engine: engine, builtins: builtins, context: object.DefaultBuiltinContext()}
+
+	switch engine {
+	case EngineEval:
+		i.env = object.NewEnvironment()
+		i.env.SetBuiltins(builtins)
+	default:
+		i.engine = EngineVM
+		i.symbolTable = compiler.NewSymbolTableWithBuiltins(builtins)
+		i.constants = []object.Object{}
+		i.globals = make([]object.Object, vm.GlobalSize)
+	}
+
+	return i
+}
+
+// Engine returns the engine the interpreter runs programs with.
+func (i *Interpreter) Engine() Engine {
+	return i.engine
+}
+
+// Builtins returns the builtins available to programs.
+func (i *Interpreter) Builtins() *object.BuiltinRegistry {
+	return i.builtins
+}
+
+// SetBuiltinContext sets the context builtins run in, which holds the
+// standard streams of programs.
+func (i *Interpreter) SetBuiltinContext(context *object.BuiltinContext) {
+	i.context = context
+	if i.env != nil {
+		i.env.SetBuiltinContext(context)
+	}
+}
+
+// SetLimits bounds the resources used by each later call of Eval or Call.
+func (i *Interpreter) SetLimits(limits object.Limits) {
+	i.limits = limits
+	if i.env != nil {
+		i.env.SetLimits(limits)
+	}
+}
+
+// Eval runs src and returns the value of its last expression statement, or
+// null if it does not end with one.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval, but stops the program when ctx ends.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, ParseErrors(p.Errors())
+	}
+
+	if i.engine == EngineEval {
+		result, err := i.evaluated(evaluator.EvalContext(ctx, program, i.env))
+		if err != nil {
+			return nil, err
+		}
+
+		if !endsWithExpression(program) {
+			return object.NULL, nil
+		}
+		return result, nil
+	}
+
+	comp := compiler.NewWithState(i.symbolTable, i.constants)
+	err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := comp.Bytecode()
+	i.constants = bytecode.Constants
+
+	machine := i.newVM(bytecode)
+	err = machine.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !endsWithExpression(program) {
+		return object.NULL, nil
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+// Set binds the global variable name to value, defining it if needed.
+func (i *Interpreter) Set(name string, value object.Object) {
+	if i.engine == EngineEval {
+		i.env.Set(name, value)
+		return
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = i.symbolTable.Define(name)
+	}
+	i.globals[symbol.Index] = value
+}
+
+// Get returns the value of the global variable name.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	if i.engine == EngineEval {
+		return i.env.Get(name)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || i.globals[symbol.Index] == nil {
+		return nil, false
+	}
+	return i.globals[symbol.Index], true
+}
+
+// Register makes fn callable from Monkey as the global function name.
+func (i *Interpreter) Register(name string, fn Func) {
+	i.Set(name, &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		result, err := fn(args...)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		return result
+	}})
+}
+
+// Call calls the global function fnName with args and returns its result.
+func (i *Interpreter) Call(fnName string, args ...object.Object) (object.Object, error) {
+	return i.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is like Call, but stops the program when ctx ends.
+func (i *Interpreter) CallContext(ctx context.Context, fnName string, args ...object.Object) (object.Object, error) {
+	fn, ok := i.Get(fnName)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", fnName)
+	}
+
+	if i.engine == EngineEval {
+		return i.evaluated(evaluator.CallContext(ctx, fn, args, i.env))
+	}
+
+	machine := i.newVM(&compiler.Bytecode{Constants: i.constants})
+	return machine.CallContext(ctx, fn, args...)
+}
+
+func (i *Interpreter) newVM(bytecode *compiler.Bytecode) *vm.VM {
+	machine := vm.NewWithGlobalsStore(bytecode, i.globals)
+	machine.SetLimits(i.limits)
+	machine.SetBuiltins(i.builtins)
+	machine.SetBuiltinContext(i.context)
+	return machine
+}
+
+// evaluated converts the result of the evaluator to the results of Eval.
+func (i *Interpreter) evaluated(result object.Object, err error) (object.Object, error) {
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, &RuntimeError{Message: errObj.Message, Stack: errObj.Stack, Err: err}
+	}
+
+	if result == nil {
+		return object.NULL, nil
+	}
+	return result, nil
+}
+
+func endsWithExpression(program *ast.Program) bool {
+	if len(program.Statements) == 0 {
+		return false
+	}
+
+	_, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+	return ok
+}
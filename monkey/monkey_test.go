@@ -0,0 +1,350 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kitasuke/monkey-go/object"
+)
+
+var engines = []Engine{EngineVM, EngineEval}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + 2`, "3"},
+		{`let x = 1;`, "null"},
+		{`let x = 1; x * 10`, "10"},
+		{`let add = fn(a, b) { a + b }; add(1, 2)`, "3"},
+		{`[1, 2, 3]`, "[1, 2, 3]"},
+		{``, "null"},
+		{`[if (true) { while (false) { } }]`, "[null]"},
+		{`[if (true) { for (a in []) { } }]`, "[null]"},
+		{`let f = fn() { for (a in [1]) { break; } }; [f()]`, "[null]"},
+		{`[if (true) { } else { 1 }]`, "[null]"},
+		{`[if (true) { let a = 1; }]`, "[null]"},
+		{`[fn() { }()]`, "[null]"},
+		{`let f = fn() { let a = 1; a = 2 }; [f()]`, "[null]"},
+		{`let f = fn() { let a = [1]; a[0] += 1 }; [f()]`, "[null]"},
+		{`let a = 1; [if (true) { a = 2 }]`, "[null]"},
+		{`[if (true) { try { 1 } catch (e) { 2 } }]`, "[null]"},
+		{`[if (false) { 1 } else { try { throw 1 } catch (e) { 2 } }]`, "[null]"},
+		{`let f = fn() { try { 1 } finally { 2 } }; [f()]`, "[null]"},
+	}
+
+	for _, engine := range engines {
+		for _, tt := range tests {
+			interp := NewWithEngine(engine)
+
+			result, err := interp.Eval(tt.input)
+			if err != nil {
+				t.Fatalf("%s: Eval(%q) failed: %s", engine, tt.input, err)
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("%s: Eval(%q) wrong. want=%q, got=%q", engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+	}
+}
+
+func TestGlobalsPersist(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+
+		_, err := interp.Eval(`let counter = 0; let inc = fn() { counter = counter + 1; counter };`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		for i := 1; i <= 3; i++ {
+			result, err := interp.Eval(`inc()`)
+			if err != nil {
+				t.Fatalf("%s: Eval failed: %s", engine, err)
+			}
+			testInteger(t, engine, result, int64(i))
+		}
+
+		counter, ok := interp.Get("counter")
+		if !ok {
+			t.Fatalf("%s: counter is not defined", engine)
+		}
+		testInteger(t, engine, counter, 3)
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+
+		interp.Set("limit", &object.Integer{Value: 5})
+		interp.Set("verbose", object.TRUE)
+
+		result, err := interp.Eval(`if (verbose) { limit * 2 } else { 0 }`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 10)
+
+		// setting an existing global updates it
+		_, err = interp.Eval(`let limit = 1; let double = fn() { limit * 2 };`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		interp.Set("limit", &object.Integer{Value: 21})
+
+		result, err = interp.Call("double")
+		if err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 42)
+
+		if _, ok := interp.Get("missing"); ok {
+			t.Errorf("%s: Get returned an undefined global", engine)
+		}
+
+		if _, ok := interp.Get("len"); ok {
+			t.Errorf("%s: Get returned a builtin", engine)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+
+		_, err := interp.Eval(`
+			let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) };
+			let adder = fn(x) { fn(y) { x + y } };
+			let addTwo = adder(2);
+			let fail = fn() { throw "failed"; };
+			let notFunction = 1;
+		`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		result, err := interp.Call("fib", &object.Integer{Value: 10})
+		if err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 55)
+
+		result, err = interp.Call("addTwo", &object.Integer{Value: 40})
+		if err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 42)
+
+		result, err = interp.Call("len", &object.String{Value: "abc"})
+		if err == nil {
+			t.Errorf("%s: calling a builtin by name succeeded with %s", engine, result.Inspect())
+		}
+
+		tests := []struct {
+			fnName   string
+			args     []object.Object
+			expected string
+		}{
+			{"fail", nil, "failed"},
+			{"fib", nil, "wrong number of arguments: want=1, got=0"},
+			{"missing", nil, "undefined function missing"},
+		}
+
+		for _, tt := range tests {
+			_, err := interp.Call(tt.fnName, tt.args...)
+			if err == nil {
+				t.Fatalf("%s: Call(%q) did not fail", engine, tt.fnName)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("%s: Call(%q) wrong error. want=%q, got=%q", engine, tt.fnName, tt.expected, err.Error())
+			}
+		}
+
+		_, err = interp.Call("notFunction")
+		if err == nil {
+			t.Errorf("%s: calling a non-function succeeded", engine)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+
+		var logged []string
+		interp.Register("log", func(args ...object.Object) (object.Object, error) {
+			for _, arg := range args {
+				logged = append(logged, arg.Inspect())
+			}
+			return nil, nil
+		})
+		interp.Register("half", func(args ...object.Object) (object.Object, error) {
+			n, ok := args[0].(*object.Integer)
+			if !ok || n.Value%2 != 0 {
+				return nil, fmt.Errorf("cannot halve %s", args[0].Inspect())
+			}
+			return &object.Integer{Value: n.Value / 2}, nil
+		})
+
+		result, err := interp.Eval(`log("start"); let r = half(8); try { half(3) } catch (e) { log(e["message"]) }; r`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 4)
+
+		expected := []string{"start", "cannot halve 3"}
+		if fmt.Sprint(logged) != fmt.Sprint(expected) {
+			t.Errorf("%s: wrong calls of log. want=%v, got=%v", engine, expected, logged)
+		}
+
+		_, err = interp.Eval(`half(5)`)
+		if err == nil || err.Error() != "cannot halve 5" {
+			t.Errorf("%s: wrong error. got=%v", engine, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+
+		_, err := interp.Eval(`let = 1;`)
+		if _, ok := err.(ParseErrors); !ok {
+			t.Errorf("%s: error is not ParseErrors. got=%T (%+v)", engine, err, err)
+		}
+
+		_, err = interp.Eval(`let f = fn() { throw "oops" }; f()`)
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("%s: error is not *RuntimeError. got=%T (%+v)", engine, err, err)
+		}
+
+		if runtimeErr.Message != "oops" {
+			t.Errorf("%s: wrong message. got=%q", engine, runtimeErr.Message)
+		}
+
+		if len(runtimeErr.Stack) != 2 || runtimeErr.Stack[0].Function != "f" {
+			t.Errorf("%s: wrong stack. got=%q", engine, runtimeErr.StackTrace())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, engine := range engines {
+		interp := NewWithEngine(engine)
+		interp.SetLimits(object.Limits{MaxInstructions: 10000})
+
+		_, err := interp.Eval(`let spin = fn() { while (true) { } };`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		_, err = interp.Call("spin")
+		if !errors.Is(err, object.ErrBudgetExceeded) {
+			t.Errorf("%s: error is not ErrBudgetExceeded. got=%T (%+v)", engine, err, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		interp.SetLimits(object.Limits{})
+		_, err = interp.EvalContext(ctx, `spin()`)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: error is not DeadlineExceeded. got=%T (%+v)", engine, err, err)
+		}
+
+		// the limits apply to each run separately
+		interp.SetLimits(object.Limits{MaxInstructions: 1000})
+		for i := 0; i < 3; i++ {
+			_, err := interp.Eval(`let x = 1; x + 1`)
+			if err != nil {
+				t.Fatalf("%s: Eval failed: %s", engine, err)
+			}
+		}
+	}
+}
+
+func testInteger(t *testing.T, engine Engine, obj object.Object, expected int64) {
+	t.Helper()
+
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("%s: object is not Integer. got=%T (%+v)", engine, obj, obj)
+		return
+	}
+
+	if result.Value != expected {
+		t.Errorf("%s: object has wrong value. want=%d, got=%d", engine, expected, result.Value)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	for _, engine := range engines {
+		sandboxed := object.DefaultBuiltins()
+		sandboxed.Remove("puts")
+
+		var printed []string
+		redirected := object.DefaultBuiltins()
+		redirected.Register("puts", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+			for _, arg := range args {
+				printed = append(printed, arg.Inspect())
+			}
+			return nil
+		}})
+
+		_, err := NewWithBuiltins(engine, sandboxed).Eval(`puts("hello")`)
+		if err == nil {
+			t.Errorf("%s: puts was not removed", engine)
+		}
+
+		_, err = NewWithBuiltins(engine, redirected).Eval(`puts("hello", len([1, 2]))`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		if fmt.Sprint(printed) != "[hello 2]" {
+			t.Errorf("%s: puts was not redirected. got=%v", engine, printed)
+		}
+
+		// the registry can change between programs
+		interp := NewWithEngine(engine)
+		interp.Builtins().Register("answer", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+			return &object.Integer{Value: 42}
+		}})
+
+		result, err := interp.Eval(`answer()`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+		testInteger(t, engine, result, 42)
+	}
+}
+
+func TestBuiltinContext(t *testing.T) {
+	for _, engine := range engines {
+		var stdout, stderr bytes.Buffer
+		interp := NewWithEngine(engine)
+		interp.SetBuiltinContext(object.NewBuiltinContext(strings.NewReader("world\n"), &stdout, &stderr))
+
+		_, err := interp.Eval(`let greet = fn() { puts("hello " + read_line()) };`)
+		if err != nil {
+			t.Fatalf("%s: Eval failed: %s", engine, err)
+		}
+
+		_, err = interp.Call("greet")
+		if err != nil {
+			t.Fatalf("%s: Call failed: %s", engine, err)
+		}
+
+		if stdout.String() != "hello world\n" {
+			t.Errorf("%s: wrong stdout. got=%q", engine, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBudgetExceeded is matched by errors.Is when a program is stopped
+// because it exceeded its Limits or its context ended.
+var ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+// Limits bounds the resources a program may use. A zero field means no
+// limit.
+type Limits struct {
+	// MaxInstructions is the number of instructions a program may execute.
+	// The evaluator counts every evaluated node as an instruction.
+	MaxInstructions int64
+	// MaxCallDepth is the number of nested function calls. Without it,
+	// calls still fail with a catchable stack overflow at a fixed depth.
+	MaxCallDepth int
+	// MaxAllocations is the number of objects a program may allocate. Arrays
+	// and hashes also count their elements, and big integers every 64 bits
+	// of their value.
+	MaxAllocations int64
+	// Timeout is the wall-clock time a program may run.
+	Timeout time.Duration
+}
+
+// BudgetError reports which limit a program exceeded. It is not catchable
+// by the program.
+type BudgetError struct {
+	Limit string // the exceeded limit, empty if the context ended
+	Err   error  // the error of the ended context
+}
+
+func (e *BudgetError) Error() string {
+	if e.Limit == "" {
+		return fmt.Sprintf("execution cancelled: %s", e.Err)
+	}
+	return fmt.Sprintf("%s limit exceeded", e.Limit)
+}
+
+func (e *BudgetError) Is(target error) bool { return target == ErrBudgetExceeded }
+func (e *BudgetError) Unwrap() error        { return e.Err }
+
+// the context and timeout are checked once every checkInterval instructions
+const checkInterval = 1024
+
+// Budget accounts for the resources used by a run of a program. Its Limits
+// only apply between Start and Stop.
+type Budget struct {
+	Limits Limits
+
+	running      bool
+	ctx          context.Context
+	deadline     time.Time
+	instructions int64
+	allocations  int64
+}
+
+// Start begins a run that ends when ctx does.
+func (b *Budget) Start(ctx context.Context) {
+	*b = Budget{Limits: b.Limits, running: true, ctx: ctx}
+	if b.Limits.Timeout > 0 {
+		b.deadline = time.Now().Add(b.Limits.Timeout)
+	}
+}
+
+// Stop ends the run.
+func (b *Budget) Stop() {
+	*b = Budget{Limits: b.Limits}
+}
+
+// Step accounts for executing one instruction.
+func (b *Budget) Step() error {
+	if !b.running {
+		return nil
+	}
+
+	b.instructions++
+	if b.Limits.MaxInstructions > 0 && b.instructions > b.Limits.MaxInstructions {
+		return &BudgetError{Limit: "instruction"}
+	}
+
+	if b.instructions%checkInterval == 0 {
+		return b.check()
+	}
+
+	return nil
+}
+
+func (b *Budget) check() error {
+	if b.ctx != nil {
+		if err := b.ctx.Err(); err != nil {
+			return &BudgetError{Err: err}
+		}
+	}
+
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return &BudgetError{Limit: "time", Err: context.DeadlineExceeded}
+	}
+
+	return nil
+}
+
+// Check checks whether the context or timeout of the run ended, which Step
+// only does periodically. It is called before operations that may take
+// long, such as arithmetic on big integers.
+func (b *Budget) Check() error {
+	if !b.running {
+		return nil
+	}
+
+	return b.check()
+}
+
+// Call accounts for entering a function call at the given depth.
+func (b *Budget) Call(depth int) error {
+	if !b.running {
+		return nil
+	}
+
+	if b.Limits.MaxCallDepth > 0 && depth > b.Limits.MaxCallDepth {
+		return &BudgetError{Limit: "call depth"}
+	}
+
+	return nil
+}
+
+// Allocate accounts for allocating obj.
+func (b *Budget) Allocate(obj Object) error {
+	if !b.running {
+		return nil
+	}
+
+	b.allocations++
+	switch obj := obj.(type) {
+	case *Array:
+		b.allocations += int64(len(obj.Elements))
+	case *Hash:
+		b.allocations += int64(obj.Len())
+	case *BigInteger:
+		b.allocations += int64(obj.Value.BitLen() / 64)
+	}
+
+	if b.Limits.MaxAllocations > 0 && b.allocations > b.Limits.MaxAllocations {
+		return &BudgetError{Limit: "allocation"}
+	}
+
+	return nil
+}
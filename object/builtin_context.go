@@ -0,0 +1,53 @@
+package object
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// BuiltinContext is passed to every builtin call. It holds the standard
+// streams of the program.
+type BuiltinContext struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	stdin *bufio.Reader
+}
+
+func NewBuiltinContext(stdin io.Reader, stdout, stderr io.Writer) *BuiltinContext {
+	return &BuiltinContext{
+		Stdout: stdout,
+		Stderr: stderr,
+		stdin:  bufio.NewReader(stdin),
+	}
+}
+
+// DefaultBuiltinContext returns a context using the standard streams of the
+// process.
+func DefaultBuiltinContext() *BuiltinContext {
+	return defaultBuiltinContext
+}
+
+// shared, so that input buffered by one program is not lost to the next
+var defaultBuiltinContext = NewBuiltinContext(os.Stdin, os.Stdout, os.Stderr)
+
+// ReadLine reads the next line of stdin without its line ending. It reports
+// false at the end of the input.
+func (c *BuiltinContext) ReadLine() (string, bool, error) {
+	line, err := c.stdin.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return "", false, nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true, nil
+}
@@ -1,6 +1,15 @@
 package object
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
 
 const (
 	BuiltinFuncNameLen   = "len"
@@ -9,15 +18,30 @@ const (
 	BuiltinFuncNameRest  = "rest"
 	BuiltinFuncNamePush  = "push"
 	BuiltinFuncNamePuts  = "puts"
+	BuiltinFuncNameInt   = "int"
+	BuiltinFuncNameFloat = "float"
+	// since the compiler refers to builtins by their index, new builtins
+	// are added last
+	BuiltinFuncNamePrint      = "print"
+	BuiltinFuncNamePrintf     = "printf"
+	BuiltinFuncNameSprintf    = "sprintf"
+	BuiltinFuncNameReadLine   = "read_line"
+	BuiltinFuncNameJSONEncode = "json_encode"
+	BuiltinFuncNameJSONDecode = "json_decode"
+	BuiltinFuncNameKeys       = "keys"
+	BuiltinFuncNameValues     = "values"
+	BuiltinFuncNameEntries    = "entries"
 )
 
-var Builtins = []struct {
+// builtins are the standard builtins, which DefaultBuiltins registers in
+// order. They must not be modified; programs get them from a registry.
+var builtins = []struct {
 	Name    string
 	Builtin *Builtin
 }{
 	{
 		BuiltinFuncNameLen,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -27,7 +51,7 @@ var Builtins = []struct {
 			case *Array:
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			default:
 				return newError("argument to %q not supported, got %s",
 					BuiltinFuncNameLen, args[0].Type())
@@ -37,9 +61,12 @@ var Builtins = []struct {
 	},
 	{
 		BuiltinFuncNamePuts,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+				_, err := fmt.Fprintln(ctx.Stdout, arg.Inspect())
+				if err != nil {
+					return newError("%s", err)
+				}
 			}
 
 			return nil
@@ -48,7 +75,7 @@ var Builtins = []struct {
 	},
 	{
 		BuiltinFuncNameFirst,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -69,7 +96,7 @@ var Builtins = []struct {
 	},
 	{
 		BuiltinFuncNameLast,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -91,7 +118,7 @@ var Builtins = []struct {
 	},
 	{
 		BuiltinFuncNameRest,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1",
 					len(args))
@@ -115,7 +142,7 @@ var Builtins = []struct {
 	},
 	{
 		BuiltinFuncNamePush,
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2",
 					len(args))
@@ -136,17 +163,374 @@ var Builtins = []struct {
 		},
 		},
 	},
+	{
+		BuiltinFuncNameInt,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer, *BigInteger:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to %s", arg.Inspect(), IntegerObj)
+				}
+				if arg.Value >= 1<<63 || arg.Value < -1<<63 {
+					value, _ := big.NewFloat(arg.Value).Int(nil)
+					return NewBigInteger(value)
+				}
+				return &Integer{Value: int64(arg.Value)}
+			case *String:
+				value, err := strconv.ParseInt(arg.Value, 0, 64)
+				if errors.Is(err, strconv.ErrRange) {
+					if value, ok := new(big.Int).SetString(arg.Value, 0); ok {
+						return &BigInteger{Value: value}
+					}
+				}
+				if err != nil {
+					return newError("could not parse %q as integer", arg.Value)
+				}
+				return &Integer{Value: value}
+			default:
+				return newError("argument to %q not supported, got %s",
+					BuiltinFuncNameInt, args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameFloat,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer, *BigInteger:
+				return &Float{Value: IntegerToFloat(arg)}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(arg.Value, 64)
+				if err != nil {
+					return newError("could not parse %q as float", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newError("argument to %q not supported, got %s",
+					BuiltinFuncNameFloat, args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		BuiltinFuncNamePrint,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			values := make([]string, len(args))
+			for i, arg := range args {
+				values[i] = arg.Inspect()
+			}
+
+			_, err := io.WriteString(ctx.Stdout, strings.Join(values, " "))
+			if err != nil {
+				return newError("%s", err)
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		BuiltinFuncNamePrintf,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			format, errObj := formatArgs(BuiltinFuncNamePrintf, args)
+			if errObj != nil {
+				return errObj
+			}
+
+			_, err := io.WriteString(ctx.Stdout, format)
+			if err != nil {
+				return newError("%s", err)
+			}
+
+			return nil
+		},
+		},
+	},
+	{
+		BuiltinFuncNameSprintf,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			format, errObj := formatArgs(BuiltinFuncNameSprintf, args)
+			if errObj != nil {
+				return errObj
+			}
+
+			return &String{Value: format}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameReadLine,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1",
+					len(args))
+			}
+
+			if len(args) == 1 {
+				_, err := io.WriteString(ctx.Stdout, args[0].Inspect())
+				if err != nil {
+					return newError("%s", err)
+				}
+			}
+
+			line, ok, err := ctx.ReadLine()
+			if err != nil {
+				return newError("%s", err)
+			}
+			if !ok {
+				return nil
+			}
+
+			return &String{Value: line}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameJSONEncode,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2",
+					len(args))
+			}
+
+			pretty := false
+			if len(args) == 2 {
+				b, ok := args[1].(*Boolean)
+				if !ok {
+					return newError("argument to %q must be %s, got %s",
+						BuiltinFuncNameJSONEncode, BooleanObj, args[1].Type())
+				}
+				pretty = b.Value
+			}
+
+			s, err := encodeJSON(args[0], pretty)
+			if err != nil {
+				return newError("%s: %s", BuiltinFuncNameJSONEncode, err)
+			}
+
+			return &String{Value: s}
+		},
+		},
+	},
+	{
+		BuiltinFuncNameJSONDecode,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != StringObj {
+				return newError("argument to %q must be %s, got %s",
+					BuiltinFuncNameJSONDecode, StringObj, args[0].Type())
+			}
+
+			obj, err := decodeJSON(args[0].(*String).Value)
+			if err != nil {
+				return newError("%s: %s", BuiltinFuncNameJSONDecode, err)
+			}
+
+			return obj
+		},
+		},
+	},
+	{
+		BuiltinFuncNameKeys,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			return hashElements(BuiltinFuncNameKeys, args, func(pair HashPair) Object {
+				return pair.Key
+			})
+		},
+		},
+	},
+	{
+		BuiltinFuncNameValues,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			return hashElements(BuiltinFuncNameValues, args, func(pair HashPair) Object {
+				return pair.Value
+			})
+		},
+		},
+	},
+	{
+		BuiltinFuncNameEntries,
+		&Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+			return hashElements(BuiltinFuncNameEntries, args, func(pair HashPair) Object {
+				return &Array{Elements: []Object{pair.Key, pair.Value}}
+			})
+		},
+		},
+	},
+}
+
+// formatArgs formats the arguments of a printf-style builtin like
+// fmt.Sprintf. Strings, numbers and booleans are passed as their Go values,
+// anything else as its Inspect() string.
+func formatArgs(name string, args []Object) (string, *Error) {
+	if len(args) == 0 {
+		return "", newError("wrong number of arguments. got=0, want=1 or more")
+	}
+
+	format, ok := args[0].(*String)
+	if !ok {
+		return "", newError("argument to %q must be %s, got %s",
+			name, StringObj, args[0].Type())
+	}
+
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *String:
+			values[i] = arg.Value
+		case *Integer:
+			values[i] = arg.Value
+		case *BigInteger:
+			values[i] = arg.Value
+		case *Float:
+			values[i] = arg.Value
+		case *Boolean:
+			values[i] = arg.Value
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+
+	return fmt.Sprintf(format.Value, values...), nil
+}
+
+// hashElements returns an array of element(pair) for the pairs of the hash
+// passed to the builtin name, in insertion order.
+func hashElements(name string, args []Object, element func(HashPair) Object) Object {
+	if len(args) != 1 {
+		return newError("wrong number of arguments. got=%d, want=1",
+			len(args))
+	}
+	if args[0].Type() != HashObj {
+		return newError("argument to %q must be %s, got %s",
+			name, HashObj, args[0].Type())
+	}
+
+	pairs := args[0].(*Hash).Pairs()
+	elements := make([]Object, len(pairs))
+	for i, pair := range pairs {
+		elements[i] = element(pair)
+	}
+
+	return &Array{Elements: elements}
 }
 
 func newError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
 }
 
-func GetBuiltinByName(name string) *Builtin {
-	for _, def := range Builtins {
+func getBuiltinByName(name string) *Builtin {
+	for _, def := range builtins {
 		if def.Name == name {
 			return def.Builtin
 		}
 	}
 	return nil
 }
+
+// MaxBuiltins is the number of builtins a registry can hold, including
+// removed ones. Compiled code refers to builtins by a one-byte index.
+const MaxBuiltins = 256
+
+// BuiltinRegistry is the set of builtin functions available to a program.
+// Compiled code refers to builtins by their index in the registry, so
+// bytecode must run with the registry it was compiled with. Removing a
+// builtin keeps the indices of the others.
+type BuiltinRegistry struct {
+	names    []string
+	builtins []*Builtin // nil for removed builtins
+	indices  map[string]int
+}
+
+func NewBuiltinRegistry() *BuiltinRegistry {
+	return &BuiltinRegistry{indices: make(map[string]int)}
+}
+
+// DefaultBuiltins returns a new registry of the standard builtins.
+func DefaultBuiltins() *BuiltinRegistry {
+	r := NewBuiltinRegistry()
+	for _, def := range builtins {
+		r.Register(def.Name, def.Builtin)
+	}
+	return r
+}
+
+// Register adds builtin as name, replacing a builtin of the same name. It
+// fails if the registry already holds MaxBuiltins builtins.
+func (r *BuiltinRegistry) Register(name string, builtin *Builtin) error {
+	if index, ok := r.indices[name]; ok {
+		r.builtins[index] = builtin
+		return nil
+	}
+
+	if len(r.builtins) >= MaxBuiltins {
+		return fmt.Errorf("cannot register %s: registry holds %d builtins", name, MaxBuiltins)
+	}
+
+	r.indices[name] = len(r.builtins)
+	r.names = append(r.names, name)
+	r.builtins = append(r.builtins, builtin)
+	return nil
+}
+
+// Remove makes the builtin name unavailable.
+func (r *BuiltinRegistry) Remove(name string) {
+	if index, ok := r.indices[name]; ok {
+		r.builtins[index] = nil
+	}
+}
+
+// Index returns the index of the builtin name.
+func (r *BuiltinRegistry) Index(name string) (int, bool) {
+	index, ok := r.indices[name]
+	if !ok || r.builtins[index] == nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// Lookup returns the builtin name.
+func (r *BuiltinRegistry) Lookup(name string) (*Builtin, bool) {
+	index, ok := r.Index(name)
+	if !ok {
+		return nil, false
+	}
+	return r.builtins[index], true
+}
+
+// At returns the builtin at index.
+func (r *BuiltinRegistry) At(index int) (*Builtin, bool) {
+	if index < 0 || index >= len(r.builtins) || r.builtins[index] == nil {
+		return nil, false
+	}
+	return r.builtins[index], true
+}
+
+// Names returns the names of the available builtins in registration order.
+func (r *BuiltinRegistry) Names() []string {
+	var names []string
+	for index, name := range r.names {
+		if r.builtins[index] != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,568 @@
+package object
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FromGo converts a Go value to an object:
+//
+//   - nil and nil pointers become null
+//   - bools, integers, floats and strings become their Monkey counterparts;
+//     big.Ints and uint64s too large for an Integer become BigIntegers
+//   - byte slices become strings, other slices and arrays become arrays
+//   - maps become hashes, with their keys in sorted order
+//   - structs become hashes keyed by their exported field names, or by the
+//     name in a `monkey:"name"` field tag; fields tagged `monkey:"-"` are
+//     left out
+//   - funcs become builtins that convert their arguments with ToGo and their
+//     results with FromGo; a non-nil error result is raised as an error
+//
+// Objects are returned unchanged. Values that refer to themselves cannot be
+// converted.
+func FromGo(v interface{}) (Object, error) {
+	if obj, ok := v.(Object); ok {
+		return obj, nil
+	}
+
+	return fromGo(reflect.ValueOf(v), map[goReference]bool{})
+}
+
+// goReference identifies the pointer, map or slice a Go value refers to.
+type goReference struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// fromGo converts v. visiting holds the pointers, maps and slices v is
+// nested in, which v must not refer to again.
+func fromGo(v reflect.Value, visiting map[goReference]bool) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			ref := goReference{typ: v.Type(), ptr: v.Pointer()}
+			if v.Kind() == reflect.Slice {
+				ref.len = v.Len()
+			}
+
+			if visiting[ref] {
+				return nil, fmt.Errorf("unsupported cyclic value")
+			}
+			visiting[ref] = true
+			defer delete(visiting, ref)
+		}
+	}
+
+	if v.CanInterface() {
+		switch value := v.Interface().(type) {
+		case Object:
+			return value, nil
+		case *big.Int:
+			if value == nil {
+				return NULL, nil
+			}
+			return NewBigInteger(new(big.Int).Set(value)), nil
+		case big.Int:
+			return NewBigInteger(new(big.Int).Set(&value)), nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewBigInteger(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGo(v.Elem(), visiting)
+	case reflect.Slice:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return &String{Value: string(v.Bytes())}, nil
+		}
+		return fromGoArray(v, visiting)
+	case reflect.Array:
+		return fromGoArray(v, visiting)
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGoMap(v, visiting)
+	case reflect.Struct:
+		return fromGoStruct(v, visiting)
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGoFunc(v)
+	default:
+		return nil, fmt.Errorf("cannot convert %s", v.Type())
+	}
+}
+
+func fromGoArray(v reflect.Value, visiting map[goReference]bool) (Object, error) {
+	elements := make([]Object, v.Len())
+
+	for i := range elements {
+		element, err := fromGo(v.Index(i), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %s", i, err)
+		}
+		elements[i] = element
+	}
+
+	return &Array{Elements: elements}, nil
+}
+
+func fromGoMap(v reflect.Value, visiting map[goReference]bool) (Object, error) {
+	hash := NewHash(v.Len())
+
+	// sorted, since the pairs of a hash are ordered
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return lessMapKey(keys[i], keys[j])
+	})
+
+	for _, mapKey := range keys {
+		key, err := fromGo(mapKey, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %s", mapKey, err)
+		}
+
+		hashKey, ok := AsHashable(key)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		value, err := fromGo(v.MapIndex(mapKey), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %s", mapKey, err)
+		}
+
+		hash.Set(hashKey, value)
+	}
+
+	return hash, nil
+}
+
+// lessMapKey orders numbers and strings by value, and other keys by their
+// formatted value.
+func lessMapKey(a, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+
+	if a.Kind() == b.Kind() {
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		}
+	}
+
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func fromGoStruct(v reflect.Value, visiting map[goReference]bool) (Object, error) {
+	hash := &Hash{}
+
+	for _, field := range structFields(v.Type()) {
+		value, err := fromGo(v.Field(field.index), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", field.name, err)
+		}
+
+		hash.Set(&String{Value: field.name}, value)
+	}
+
+	return hash, nil
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func fromGoFunc(fn reflect.Value) (Object, error) {
+	t := fn.Type()
+
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if returnsError {
+		numOut--
+	}
+	if numOut > 1 {
+		return nil, fmt.Errorf("cannot convert %s: too many results", t)
+	}
+
+	numIn := t.NumIn()
+
+	return &Builtin{Fn: func(ctx *BuiltinContext, args ...Object) Object {
+		if len(args) != numIn && !(t.IsVariadic() && len(args) >= numIn-1) {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				paramType = t.In(numIn - 1).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+
+			in[i] = reflect.New(paramType).Elem()
+			err := toGo(arg, in[i])
+			if err != nil {
+				return newError("argument %d: %s", i+1, err)
+			}
+		}
+
+		out := fn.Call(in)
+
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return newError("%s", err)
+			}
+		}
+
+		if numOut == 0 {
+			return nil
+		}
+
+		result, err := fromGo(out[0], map[goReference]bool{})
+		if err != nil {
+			return newError("result: %s", err)
+		}
+		return result
+	}}, nil
+}
+
+// ToGo stores the value of obj in the value target points to, converting
+// it like FromGo in reverse. Hashes can be stored in maps and structs, in
+// which keys without a matching field are ignored. An interface{} target
+// gets int64, *big.Int, float64, string, bool, nil, []interface{} or
+// map[string]interface{} (map[interface{}]interface{} for hashes with keys
+// other than strings), and any other object unchanged.
+func ToGo(obj Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+
+	return toGo(obj, v.Elem())
+}
+
+func toGo(obj Object, v reflect.Value) error {
+	t := v.Type()
+
+	if reflect.TypeOf(obj).AssignableTo(t) && t.Kind() != reflect.Interface {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if t.Kind() == reflect.Interface {
+		if t.NumMethod() > 0 {
+			if !reflect.TypeOf(obj).Implements(t) {
+				return cannotConvert(obj, t)
+			}
+			v.Set(reflect.ValueOf(obj))
+			return nil
+		}
+
+		value, err := toGoValue(obj)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func:
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+	}
+
+	if t == bigIntType {
+		if !IsInteger(obj) {
+			return cannotConvert(obj, t)
+		}
+		v.Set(reflect.ValueOf(*new(big.Int).Set(bigInt(obj))))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(t.Elem())
+		err := toGo(obj, elem.Elem())
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		boolean, ok := obj.(*Boolean)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+		v.SetBool(boolean.Value)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if obj.Type() == BigIntegerObj {
+			return fmt.Errorf("%s overflows %s", obj.Inspect(), t)
+		}
+		integer, ok := obj.(*Integer)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+		if v.OverflowInt(integer.Value) {
+			return fmt.Errorf("%d overflows %s", integer.Value, t)
+		}
+		v.SetInt(integer.Value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !IsInteger(obj) {
+			return cannotConvert(obj, t)
+		}
+		integer := bigInt(obj)
+		if !integer.IsUint64() || v.OverflowUint(integer.Uint64()) {
+			return fmt.Errorf("%s overflows %s", integer, t)
+		}
+		v.SetUint(integer.Uint64())
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch number := obj.(type) {
+		case *Integer, *BigInteger:
+			v.SetFloat(IntegerToFloat(number))
+		case *Float:
+			v.SetFloat(number.Value)
+		default:
+			return cannotConvert(obj, t)
+		}
+		return nil
+	case reflect.String:
+		str, ok := obj.(*String)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+		v.SetString(str.Value)
+		return nil
+	case reflect.Slice:
+		if str, ok := obj.(*String); ok && t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(str.Value))
+			return nil
+		}
+
+		array, ok := obj.(*Array)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+
+		slice := reflect.MakeSlice(t, len(array.Elements), len(array.Elements))
+		for i, element := range array.Elements {
+			err := toGo(element, slice.Index(i))
+			if err != nil {
+				return fmt.Errorf("index %d: %s", i, err)
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		array, ok := obj.(*Array)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+		if len(array.Elements) != t.Len() {
+			return fmt.Errorf("cannot convert %s of length %d to %s", ArrayObj, len(array.Elements), t)
+		}
+
+		for i, element := range array.Elements {
+			err := toGo(element, v.Index(i))
+			if err != nil {
+				return fmt.Errorf("index %d: %s", i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+
+		m := reflect.MakeMapWithSize(t, hash.Len())
+		for _, pair := range hash.Pairs() {
+			key := reflect.New(t.Key()).Elem()
+			err := toGo(pair.Key, key)
+			if err != nil {
+				return fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+
+			value := reflect.New(t.Elem()).Elem()
+			err = toGo(pair.Value, value)
+			if err != nil {
+				return fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return cannotConvert(obj, t)
+		}
+
+		for _, field := range structFields(t) {
+			value, ok := hash.Get(&String{Value: field.name})
+			if !ok {
+				continue
+			}
+
+			err := toGo(value, v.Field(field.index))
+			if err != nil {
+				return fmt.Errorf("field %s: %s", field.name, err)
+			}
+		}
+		return nil
+	default:
+		return cannotConvert(obj, t)
+	}
+}
+
+// toGoValue converts obj for an interface{} target.
+func toGoValue(obj Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *BigInteger:
+		return new(big.Int).Set(obj.Value), nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := toGoValue(element)
+			if err != nil {
+				return nil, fmt.Errorf("index %d: %s", i, err)
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *Hash:
+		stringKeys := true
+		for _, pair := range obj.Pairs() {
+			if _, ok := pair.Key.(*String); !ok {
+				stringKeys = false
+			}
+		}
+
+		if stringKeys {
+			m := make(map[string]interface{}, obj.Len())
+			for _, pair := range obj.Pairs() {
+				value, err := toGoValue(pair.Value)
+				if err != nil {
+					return nil, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+				}
+				m[pair.Key.(*String).Value] = value
+			}
+			return m, nil
+		}
+
+		m := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			// slices are not comparable
+			if pair.Key.Type() == ArrayObj {
+				return nil, fmt.Errorf("key %s: unsupported hash key %s", pair.Key.Inspect(), ArrayObj)
+			}
+
+			key, err := toGoValue(pair.Key)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+
+			value, err := toGoValue(pair.Value)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+			m[key] = value
+		}
+		return m, nil
+	default:
+		return obj, nil
+	}
+}
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+func cannotConvert(obj Object, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+type structField struct {
+	name  string
+	index int
+}
+
+// structFields returns the exported fields of t with their hash keys.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag = strings.Split(tag, ",")[0]; tag != "" {
+				name = tag
+			}
+		}
+
+		fields = append(fields, structField{name: name, index: i})
+	}
+
+	return fields
+}
@@ -1,21 +1,99 @@
 package object
 
+import "github.com/kitasuke/monkey-go/token"
+
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	outer   *Environment
+	call    *Call
+	program *program
+}
+
+// program is the state shared by all environments of a program.
+type program struct {
+	budget   Budget
+	builtins *BuiltinRegistry
+	context  *BuiltinContext
+}
+
+// Call describes the function call an environment was created for.
+type Call struct {
+	Function string         // name of the called function, empty if anonymous
+	Pos      token.Position // position of the call expression
+	Caller   *Environment   // environment the call was made from
+	Depth    int            // number of calls including this one
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s}
+	p := &program{builtins: DefaultBuiltins(), context: DefaultBuiltinContext()}
+	return &Environment{store: s, program: p}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: outer, program: outer.program}
+}
+
+// NewCallEnvironment creates the environment of a function call, enclosed
+// in the environment of the function.
+func NewCallEnvironment(outer *Environment, call *Call) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.call = call
 	return env
 }
 
+// SetLimits bounds the resources used by evaluations in the program.
+func (e *Environment) SetLimits(limits Limits) {
+	e.program.budget.Limits = limits
+}
+
+// Budget returns the budget of the program.
+func (e *Environment) Budget() *Budget {
+	return &e.program.budget
+}
+
+// SetBuiltins replaces the builtins available to the program.
+func (e *Environment) SetBuiltins(builtins *BuiltinRegistry) {
+	e.program.builtins = builtins
+}
+
+// Builtins returns the builtins available to the program.
+func (e *Environment) Builtins() *BuiltinRegistry {
+	return e.program.builtins
+}
+
+// SetBuiltinContext replaces the context builtins of the program run in.
+func (e *Environment) SetBuiltinContext(context *BuiltinContext) {
+	e.program.context = context
+}
+
+// BuiltinContext returns the context builtins of the program run in.
+func (e *Environment) BuiltinContext() *BuiltinContext {
+	return e.program.context
+}
+
+// CallDepth returns the number of function calls e is nested in.
+func (e *Environment) CallDepth() int {
+	if e.call == nil {
+		return 0
+	}
+	return e.call.Depth
+}
+
+// StackTrace returns the call stack of e, where pos is the position being
+// evaluated in e.
+func (e *Environment) StackTrace(pos token.Position) StackTrace {
+	var stack StackTrace
+
+	for env := e; env.call != nil; env = env.call.Caller {
+		stack = append(stack, StackFrame{Function: env.call.Function, Pos: pos})
+		pos = env.call.Pos
+	}
+
+	return append(stack, StackFrame{Function: MainFunctionName, Pos: pos})
+}
+
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
 	if !ok && e.outer != nil {
@@ -28,3 +106,18 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign updates an existing binding in the innermost environment that
+// defines name. It reports false if name is not bound.
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return val, true
+	}
+
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+
+	return nil, false
+}
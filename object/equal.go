@@ -0,0 +1,119 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// Equal reports whether a and b are equal values, which is what == compares
+// in Monkey:
+//
+//   - integers and floats are equal if their numeric values are
+//   - strings and booleans are equal if their values are
+//   - arrays are equal if their elements are, in order
+//   - hashes are equal if they have the same keys with equal values, in any
+//     order
+//   - null is only equal to null
+//
+// Values of different types are not equal, and any other objects are only
+// equal to themselves.
+func Equal(a, b Object) bool {
+	return equal(a, b, nil)
+}
+
+// comparison is a pair of arrays or hashes being compared.
+type comparison struct {
+	a, b Object
+}
+
+// equal compares a and b. Arrays and hashes that contain themselves are
+// considered equal while they are compared to the same values again.
+func equal(a, b Object, comparing map[comparison]bool) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Integer, *BigInteger:
+		switch b := b.(type) {
+		case *Integer, *BigInteger:
+			return CompareIntegers(a, b) == 0
+		case *Float:
+			return integerEqualsFloat(a, b.Value)
+		}
+		return false
+	case *Float:
+		switch b := b.(type) {
+		case *Integer, *BigInteger:
+			return integerEqualsFloat(b, a.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+		return false
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+
+		if comparing[comparison{a, b}] {
+			return true
+		}
+		comparing = compare(comparing, a, b)
+
+		for i := range a.Elements {
+			if !equal(a.Elements[i], b.Elements[i], comparing) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+
+		if comparing[comparison{a, b}] {
+			return true
+		}
+		comparing = compare(comparing, a, b)
+
+		for _, pair := range a.Pairs() {
+			value, ok := b.Get(pair.Key.(Hashable))
+			if !ok || !equal(pair.Value, value, comparing) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// integerEqualsFloat reports whether the integer i has the value f exactly.
+func integerEqualsFloat(i Object, f float64) bool {
+	if integer, ok := i.(*Integer); ok {
+		return float64(integer.Value) == f
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	return new(big.Float).SetInt(bigInt(i)).Cmp(big.NewFloat(f)) == 0
+}
+
+func compare(comparing map[comparison]bool, a, b Object) map[comparison]bool {
+	if comparing == nil {
+		comparing = make(map[comparison]bool)
+	}
+	comparing[comparison{a, b}] = true
+	return comparing
+}
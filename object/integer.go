@@ -0,0 +1,230 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/kitasuke/monkey-go/token"
+)
+
+// MaxIntegerBits bounds the size of the results of integer arithmetic, so
+// that a program cannot allocate arbitrarily large BigIntegers or make a
+// single operation take arbitrarily long.
+const MaxIntegerBits = 1 << 20
+
+// NewBigInteger returns v as an Integer if it fits in one, and as a
+// BigInteger otherwise. v must not be modified afterwards.
+func NewBigInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+// IsInteger reports whether obj is an Integer or a BigInteger.
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInteger:
+		return true
+	default:
+		return false
+	}
+}
+
+// bigInt returns the value of the Integer or BigInteger obj. The result
+// must not be modified.
+func bigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	default:
+		return nil
+	}
+}
+
+// IntegerInfix applies an arithmetic, bitwise or shift operator to the
+// integers left and right. Results that overflow an Integer are returned as
+// a BigInteger.
+func IntegerInfix(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		result, ok, err := int64Infix(operator, l.Value, r.Value)
+		if err != nil || ok {
+			return result, err
+		}
+	}
+
+	x, y := bigInt(left), bigInt(right)
+	result := new(big.Int)
+
+	switch operator {
+	case token.Plus:
+		result.Add(x, y)
+	case token.Minus:
+		result.Sub(x, y)
+	case token.Asterisk:
+		if x.BitLen()+y.BitLen() > MaxIntegerBits+1 {
+			return nil, tooLarge()
+		}
+		result.Mul(x, y)
+	case token.Slash, token.Percent:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if operator == token.Slash {
+			result.Quo(x, y)
+		} else {
+			result.Rem(x, y)
+		}
+	case token.Ampersand:
+		result.And(x, y)
+	case token.Pipe:
+		result.Or(x, y)
+	case token.Caret:
+		result.Xor(x, y)
+	case token.ShiftLeft:
+		if y.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count: %s", y)
+		}
+		if !y.IsInt64() || y.Int64() > MaxIntegerBits {
+			return nil, fmt.Errorf("shift count too large: %s", y)
+		}
+		if int64(x.BitLen())+y.Int64() > MaxIntegerBits {
+			return nil, tooLarge()
+		}
+		result.Lsh(x, uint(y.Int64()))
+	case token.ShiftRight:
+		if y.Sign() < 0 {
+			return nil, fmt.Errorf("negative shift count: %s", y)
+		}
+		// shifting by the length of x already leaves only its sign
+		n := uint(x.BitLen())
+		if y.IsInt64() && y.Int64() < int64(n) {
+			n = uint(y.Int64())
+		}
+		result.Rsh(x, n)
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	if result.BitLen() > MaxIntegerBits {
+		return nil, tooLarge()
+	}
+
+	return NewBigInteger(result), nil
+}
+
+func tooLarge() error {
+	return fmt.Errorf("integer too large: more than %d bits", MaxIntegerBits)
+}
+
+// int64Infix is IntegerInfix for two Integers. It reports false if the
+// result overflows.
+func int64Infix(operator string, x, y int64) (Object, bool, error) {
+	var result int64
+
+	switch operator {
+	case token.Plus:
+		result = x + y
+		if (result > x) != (y > 0) {
+			return nil, false, nil
+		}
+	case token.Minus:
+		result = x - y
+		if (result < x) != (y > 0) {
+			return nil, false, nil
+		}
+	case token.Asterisk:
+		if x == 0 || y == 0 {
+			break
+		}
+		result = x * y
+		if result/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64) {
+			return nil, false, nil
+		}
+	case token.Slash:
+		if y == 0 {
+			return nil, false, fmt.Errorf("division by zero")
+		}
+		if x == math.MinInt64 && y == -1 {
+			return nil, false, nil
+		}
+		result = x / y
+	case token.Percent:
+		if y == 0 {
+			return nil, false, fmt.Errorf("division by zero")
+		}
+		result = x % y
+	case token.Ampersand:
+		result = x & y
+	case token.Pipe:
+		result = x | y
+	case token.Caret:
+		result = x ^ y
+	case token.ShiftLeft:
+		if y < 0 {
+			return nil, false, fmt.Errorf("negative shift count: %d", y)
+		}
+		if y >= 63 || (x<<y)>>y != x {
+			return nil, false, nil
+		}
+		result = x << y
+	case token.ShiftRight:
+		if y < 0 {
+			return nil, false, fmt.Errorf("negative shift count: %d", y)
+		}
+		result = x >> y
+	default:
+		return nil, false, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+
+	return &Integer{Value: result}, true, nil
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether the integer left
+// is less than, equal to or greater than the integer right.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	return bigInt(left).Cmp(bigInt(right))
+}
+
+// NegateInteger returns -obj for the integer obj.
+func NegateInteger(obj Object) Object {
+	if integer, ok := obj.(*Integer); ok && integer.Value != math.MinInt64 {
+		return &Integer{Value: -integer.Value}
+	}
+	return NewBigInteger(new(big.Int).Neg(bigInt(obj)))
+}
+
+// ComplementInteger returns the bitwise complement of the integer obj.
+func ComplementInteger(obj Object) Object {
+	if integer, ok := obj.(*Integer); ok {
+		return &Integer{Value: ^integer.Value}
+	}
+	return NewBigInteger(new(big.Int).Not(bigInt(obj)))
+}
+
+// IntegerToFloat returns the integer obj as the nearest float.
+func IntegerToFloat(obj Object) float64 {
+	if integer, ok := obj.(*Integer); ok {
+		return float64(integer.Value)
+	}
+	f, _ := new(big.Float).SetInt(bigInt(obj)).Float64()
+	return f
+}
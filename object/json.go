@@ -0,0 +1,256 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// encodeJSON encodes obj as JSON. Hash keys must be strings or integers, and
+// are kept in their order.
+func encodeJSON(obj Object, pretty bool) (string, error) {
+	value, err := jsonValue(obj, map[Object]bool{})
+	if err != nil {
+		return "", err
+	}
+
+	indent := ""
+	if pretty {
+		indent = "  "
+	}
+	return marshalJSON(value, indent)
+}
+
+// marshalJSON is json.Marshal without escaping HTML characters.
+func marshalJSON(value interface{}, indent string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+
+	err := enc.Encode(value)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonObject is a JSON object that keeps the order of its members.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString("{")
+	for i, member := range o {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+
+		key, err := marshalJSON(member.key, "")
+		if err != nil {
+			return nil, err
+		}
+		value, err := marshalJSON(member.value, "")
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteString(key)
+		buf.WriteString(":")
+		buf.WriteString(value)
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes(), nil
+}
+
+// jsonValue converts obj to a value encoding/json encodes as obj. Numbers
+// are kept as json.Number, so that floats keep their Inspect() form.
+// visiting holds the arrays and hashes obj is nested in, which obj must not
+// contain again.
+func jsonValue(obj Object, visiting map[Object]bool) (interface{}, error) {
+	switch obj.(type) {
+	case *Array, *Hash:
+		if visiting[obj] {
+			return nil, fmt.Errorf("unsupported cyclic value")
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+	}
+
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer, *BigInteger:
+		return json.Number(obj.Inspect()), nil
+	case *Float:
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return nil, fmt.Errorf("unsupported float value %s", obj.Inspect())
+		}
+		return json.Number(obj.Inspect()), nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := jsonValue(element, visiting)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case *Hash:
+		members := make(jsonObject, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			var key string
+			switch k := pair.Key.(type) {
+			case *String:
+				key = k.Value
+			case *Integer, *BigInteger:
+				key = k.Inspect()
+			default:
+				return nil, fmt.Errorf("unsupported hash key %s", pair.Key.Type())
+			}
+
+			value, err := jsonValue(pair.Value, visiting)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, jsonMember{key: key, value: value})
+		}
+		return members, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", obj.Type())
+	}
+}
+
+// decodeJSON decodes a single JSON value. Numbers without a fraction or
+// exponent that fit in an integer become integers, other numbers floats.
+func decodeJSON(s string) (Object, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+
+	obj, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, jsonError(err, dec)
+	}
+
+	_, err = dec.Token()
+	if err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after top-level value")
+		}
+		return nil, jsonError(err, dec)
+	}
+
+	return obj, nil
+}
+
+func decodeJSONValue(dec *json.Decoder) (Object, error) {
+	token, err := dec.Token()
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case nil:
+		return NULL, nil
+	case bool:
+		if token {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case json.Number:
+		return decodeJSONNumber(token)
+	case string:
+		return &String{Value: token}, nil
+	case json.Delim:
+		if token == '[' {
+			elements := []Object{}
+			for dec.More() {
+				element, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+
+			// the closing delimiter
+			_, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			return &Array{Elements: elements}, nil
+		}
+
+		hash := &Hash{}
+		for dec.More() {
+			keyToken, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			hash.Set(&String{Value: keyToken.(string)}, value)
+		}
+
+		_, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("unexpected token %v", token)
+	}
+}
+
+func decodeJSONNumber(n json.Number) (Object, error) {
+	if !strings.ContainsAny(n.String(), ".eE") {
+		value, ok := new(big.Int).SetString(n.String(), 10)
+		if ok {
+			return NewBigInteger(value), nil
+		}
+	}
+
+	value, err := strconv.ParseFloat(n.String(), 64)
+	if err != nil {
+		return nil, fmt.Errorf("number %s out of range", n)
+	}
+	return &Float{Value: value}, nil
+}
+
+// jsonError adds the offset of the decoder to err.
+func jsonError(err error, dec *json.Decoder) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("unexpected end of JSON input")
+	}
+
+	offset := dec.InputOffset()
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		offset = syntaxErr.Offset
+	}
+	return fmt.Errorf("%s at offset %d", err, offset)
+}
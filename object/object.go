@@ -2,8 +2,12 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/kitasuke/monkey-go/ast"
@@ -15,9 +19,13 @@ type ObjectType string
 
 const (
 	IntegerObj          = "Integer"
+	BigIntegerObj       = "BigInteger"
+	FloatObj            = "Float"
 	BooleanObj          = "Boolean"
 	NullObj             = "Null"
 	ReturnValueObj      = "ReturnValue"
+	BreakObj            = "Break"
+	ContinueObj         = "Continue"
 	ErrorObj            = "Error"
 	FunctionObj         = "Function"
 	StringObj           = "String"
@@ -26,17 +34,123 @@ const (
 	HashObj             = "Hash"
 	CompiledFunctionObj = "CompiledFunction"
 	ClosureObj          = "Closure"
+	CellObj             = "Cell"
+	ExceptionObj        = "Exception"
 )
 
+// MainFunctionName names the top-level program in stack traces.
+const MainFunctionName = "<main>"
+
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
 }
 
+// Hashable is implemented by objects that can be hash keys. Distinct keys
+// may have the same HashKey; hashes tell them apart by their values. Numbers
+// with the same value, such as 2 and 2.0, are the same key.
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// AsHashable returns obj as a hash key. Arrays are only usable as keys if
+// all their elements are.
+func AsHashable(obj Object) (Hashable, bool) {
+	if array, ok := obj.(*Array); ok {
+		for _, element := range array.Elements {
+			if _, ok := AsHashable(element); !ok {
+				return nil, false
+			}
+		}
+	}
+
+	key, ok := obj.(Hashable)
+	return key, ok
+}
+
+// keysEqual reports whether the hash keys a and b have the same value.
+func keysEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer, *BigInteger, *Float:
+		return numberKeysEqual(a, b)
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !keysEqual(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// numberKeysEqual reports whether the numbers a and b have exactly the same
+// value. A NaN key is only equal to a NaN with the same bits.
+func numberKeysEqual(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		if b, ok := b.(*Integer); ok {
+			return a.Value == b.Value
+		}
+	case *Float:
+		if b, ok := b.(*Float); ok {
+			return a.Value == b.Value || math.Float64bits(a.Value) == math.Float64bits(b.Value)
+		}
+	}
+
+	x, ok := exactNumber(a)
+	if !ok {
+		return false
+	}
+	y, ok := exactNumber(b)
+	return ok && x.Cmp(y) == 0
+}
+
+// exactNumber returns the value of the number obj without rounding. NaN has
+// no such value.
+func exactNumber(obj Object) (*big.Float, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return new(big.Float).SetInt64(obj.Value), true
+	case *BigInteger:
+		return new(big.Float).SetInt(obj.Value), true
+	case *Float:
+		if math.IsNaN(obj.Value) {
+			return nil, false
+		}
+		return big.NewFloat(obj.Value), true
+	default:
+		return nil, false
+	}
+}
+
+// copyKey copies the arrays in key, so that changing them does not change
+// the key.
+func copyKey(key Hashable) Hashable {
+	array, ok := key.(*Array)
+	if !ok {
+		return key
+	}
+
+	elements := make([]Object, len(array.Elements))
+	for i, element := range array.Elements {
+		elements[i] = copyKey(element.(Hashable))
+	}
+	return &Array{Elements: elements}
+}
+
 type Object interface {
 	Type() ObjectType
 	Inspect() string
@@ -52,6 +166,53 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// BigInteger is an integer that does not fit in an Integer. Integer
+// arithmetic returns a BigInteger only for results that do not fit.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Type() ObjectType { return BigIntegerObj }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+func (bi *BigInteger) HashKey() HashKey {
+	if bi.Value.IsInt64() {
+		return (&Integer{Value: bi.Value.Int64()}).HashKey()
+	}
+
+	h := fnv.New64a()
+	if bi.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(bi.Value.Bytes())
+
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FloatObj }
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		// keep floats with integral values distinguishable from integers
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) HashKey() HashKey {
+	// whole floats are the same keys as the integers with their values
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= -1<<63 && f.Value < 1<<63 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		i, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: i}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -75,6 +236,14 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NullObj }
 func (n *Null) Inspect() string  { return "null" }
 
+// TRUE, FALSE and NULL are the only values of their kind. Both engines
+// compare them by identity, so values passed in from Go must use them.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
 type ReturnValue struct {
 	Value Object
 }
@@ -82,8 +251,23 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return ReturnValueObj }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BreakObj }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return ContinueObj }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Error is a runtime error in the evaluator, raised by the interpreter or by
+// throw. It unwinds evaluation until it is caught.
 type Error struct {
 	Message string
+	Value   Object     // the thrown value, nil for errors of the interpreter
+	Stack   StackTrace // the call stack where the error was raised
+	Err     error      // the *BudgetError that stopped the evaluation, if any
 }
 
 func (e *Error) Type() ObjectType { return ErrorObj }
@@ -93,6 +277,7 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // empty for anonymous functions
 }
 
 func (f *Function) Type() ObjectType { return FunctionObj }
@@ -127,7 +312,7 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(ctx *BuiltinContext, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -141,6 +326,22 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ArrayObj }
+
+// HashKey combines the hash keys of the elements, which must all be
+// Hashable. Use AsHashable to check.
+func (ao *Array) HashKey() HashKey {
+	h := fnv.New64a()
+
+	var buf [8]byte
+	for _, element := range ao.Elements {
+		key := element.(Hashable).HashKey()
+		h.Write([]byte(key.Type))
+		binary.LittleEndian.PutUint64(buf[:], key.Value)
+		h.Write(buf[:])
+	}
+
+	return HashKey{Type: ao.Type(), Value: h.Sum64()}
+}
 func (ao *Array) Inspect() string {
 	var out bytes.Buffer
 
@@ -161,8 +362,61 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps hashable keys to values. It keeps its pairs in the order their
+// keys were first set. The zero value is an empty hash.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	buckets map[HashKey][]int // indices in pairs of the keys with a HashKey
+	pairs   []HashPair
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{buckets: make(map[HashKey][]int, size), pairs: make([]HashPair, 0, size)}
+}
+
+// Get returns the value of key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	index, ok := h.index(key, key.HashKey())
+	if !ok {
+		return nil, false
+	}
+	return h.pairs[index].Value, true
+}
+
+// Set sets the value of key. A new key is added after all others, an
+// existing one keeps its position. Array keys are copied.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if index, ok := h.index(key, hashKey); ok {
+		h.pairs[index].Value = value
+		return
+	}
+
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: copyKey(key), Value: value})
+}
+
+func (h *Hash) index(key Hashable, hashKey HashKey) (int, bool) {
+	for _, index := range h.buckets[hashKey] {
+		if keysEqual(h.pairs[index].Key, key) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs in insertion order. The slice must not be
+// modified.
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
 }
 
 func (h *Hash) Type() ObjectType { return HashObj }
@@ -170,7 +424,7 @@ func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	var pairs []string
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -185,6 +439,8 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	Name          string // empty for anonymous functions
+	Lines         code.LineTable
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return CompiledFunctionObj }
@@ -192,6 +448,8 @@ func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CopiledFunction[%p]", cf)
 }
 
+// Closure is a compiled function together with the cells of the variables
+// it captured from enclosing functions.
 type Closure struct {
 	Fn   *CompiledFunction
 	Free []Object
@@ -202,3 +460,82 @@ func (c *Closure) Type() ObjectType { return ClosureObj }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// Exception is the value bound by a catch block. Value is the thrown value,
+// or the message for errors raised by the interpreter.
+type Exception struct {
+	Message string
+	Value   Object
+	Stack   StackTrace
+}
+
+func (e *Exception) Type() ObjectType { return ExceptionObj }
+func (e *Exception) Inspect() string  { return "Exception: " + e.Message }
+
+// Field returns the field of e with the given name: "message", "value" or
+// "stack", the latter an array of strings, innermost call first.
+func (e *Exception) Field(name string) (Object, bool) {
+	switch name {
+	case "message":
+		return &String{Value: e.Message}, true
+	case "value":
+		return e.Value, true
+	case "stack":
+		elements := make([]Object, len(e.Stack))
+		for i, frame := range e.Stack {
+			elements[i] = &String{Value: frame.String()}
+		}
+		return &Array{Elements: elements}, true
+	default:
+		return nil, false
+	}
+}
+
+// ExceptionMessage returns the message of an exception raised by throwing
+// value.
+func ExceptionMessage(value Object) string {
+	if str, ok := value.(*String); ok {
+		return str.Value
+	}
+	return value.Inspect()
+}
+
+// StackFrame describes a function call that is active when an error is
+// raised.
+type StackFrame struct {
+	Function string // empty for anonymous functions
+	Pos      token.Position
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	return fmt.Sprintf("%s (%s)", name, f.Pos)
+}
+
+// StackTrace is a call stack, innermost call first.
+type StackTrace []StackFrame
+
+// String formats the call stack, one frame per line.
+func (st StackTrace) String() string {
+	var out bytes.Buffer
+
+	for _, frame := range st {
+		fmt.Fprintf(&out, "\tat %s\n", frame)
+	}
+
+	return out.String()
+}
+
+// Cell boxes a local variable captured by closures, so that assignments to
+// it are shared between the defining function and all closures.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CellObj }
+func (c *Cell) Inspect() string {
+	return fmt.Sprintf("Cell[%p]", c)
+}
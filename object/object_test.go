@@ -1,6 +1,17 @@
 package object
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kitasuke/monkey-go/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -14,3 +25,624 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with same content have different hash keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	hash := &Hash{}
+	for _, key := range []string{"c", "a", "b", "a"} {
+		hash.Set(&String{Value: key}, &Integer{Value: int64(hash.Len())})
+	}
+
+	expected := "{c: 0, a: 3, b: 2}"
+	if hash.Inspect() != expected {
+		t.Errorf("wrong Inspect(). want=%q, got=%q", expected, hash.Inspect())
+	}
+
+	if value, ok := hash.Get(&String{Value: "b"}); !ok || value.Inspect() != "2" {
+		t.Errorf("wrong value for b. got=%v (%t)", value, ok)
+	}
+	if _, ok := hash.Get(&String{Value: "d"}); ok {
+		t.Errorf("value for missing key d")
+	}
+}
+
+// collidingKey has the same HashKey as every other collidingKey.
+type collidingKey struct{ name string }
+
+func (k *collidingKey) Type() ObjectType { return "Colliding" }
+func (k *collidingKey) Inspect() string  { return k.name }
+func (k *collidingKey) HashKey() HashKey { return HashKey{Type: k.Type()} }
+
+func TestHashKeyCollisions(t *testing.T) {
+	a, b := &collidingKey{name: "a"}, &collidingKey{name: "b"}
+
+	hash := &Hash{}
+	hash.Set(a, &Integer{Value: 1})
+	hash.Set(b, &Integer{Value: 2})
+
+	if hash.Len() != 2 {
+		t.Fatalf("colliding keys overwrote each other. got=%s", hash.Inspect())
+	}
+	for key, expected := range map[Hashable]string{a: "1", b: "2"} {
+		if value, ok := hash.Get(key); !ok || value.Inspect() != expected {
+			t.Errorf("wrong value for %s. want=%s, got=%v", key.Inspect(), expected, value)
+		}
+	}
+	if _, ok := hash.Get(&collidingKey{name: "c"}); ok {
+		t.Errorf("value for missing colliding key")
+	}
+}
+
+func TestArrayKeys(t *testing.T) {
+	array := func(elements ...Object) *Array { return &Array{Elements: elements} }
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+
+	if array(one, two).HashKey() != array(one, two).HashKey() {
+		t.Errorf("equal arrays have different hash keys")
+	}
+	if array(one, two).HashKey() == array(two, one).HashKey() ||
+		array(one).HashKey() == array(&String{Value: "1"}).HashKey() ||
+		array(array(one)).HashKey() == array(one).HashKey() {
+		t.Errorf("different arrays have the same hash key")
+	}
+
+	if _, ok := AsHashable(array(one, array(two))); !ok {
+		t.Errorf("array of hashable values is not hashable")
+	}
+	if _, ok := AsHashable(array(one, array(&Hash{}))); ok {
+		t.Errorf("array containing a hash is hashable")
+	}
+
+	key := array(one, array(two))
+	hash := &Hash{}
+	hash.Set(key, TRUE)
+	key.Elements[1].(*Array).Elements[0] = one
+
+	if _, ok := hash.Get(array(one, array(two))); !ok {
+		t.Errorf("changing an array changed the hash key")
+	}
+	if _, ok := hash.Get(key); ok {
+		t.Errorf("changed array found in hash")
+	}
+}
+
+func TestNumberKeys(t *testing.T) {
+	huge, _ := new(big.Int).SetString("18446744073709551616", 10)
+	tests := []struct {
+		key, same Hashable
+	}{
+		{&Integer{Value: 2}, &Float{Value: 2}},
+		{&Integer{Value: 0}, &Float{Value: math.Copysign(0, -1)}},
+		{&Integer{Value: math.MinInt64}, &Float{Value: -1 << 63}},
+		{&BigInteger{Value: huge}, &Float{Value: 1 << 64}},
+		{&BigInteger{Value: big.NewInt(3)}, &Integer{Value: 3}},
+		{&Float{Value: 0.5}, &Float{Value: 0.5}},
+		{&Float{Value: math.NaN()}, &Float{Value: math.NaN()}},
+	}
+
+	for _, tt := range tests {
+		hash := &Hash{}
+		hash.Set(tt.key, TRUE)
+		if _, ok := hash.Get(tt.same); !ok {
+			t.Errorf("%s is not the same key as %s", tt.same.Inspect(), tt.key.Inspect())
+		}
+	}
+
+	hash := &Hash{}
+	hash.Set(&Integer{Value: 1 << 53}, TRUE)
+	for _, key := range []Hashable{
+		&Integer{Value: 1<<53 + 1},
+		&Float{Value: 1<<53 + 2},
+		&Float{Value: math.Inf(1)},
+		&String{Value: "9007199254740992"},
+	} {
+		if _, ok := hash.Get(key); ok {
+			t.Errorf("%s is the same key as 9007199254740992", key.Inspect())
+		}
+	}
+
+	a, b := &Hash{}, &Hash{}
+	a.Set(&Integer{Value: 2}, TRUE)
+	b.Set(&Float{Value: 2}, TRUE)
+	if !Equal(a, b) {
+		t.Errorf("hashes with the keys 2 and 2.0 are not equal")
+	}
+}
+
+func TestIntegerInfix(t *testing.T) {
+	tests := []struct {
+		operator string
+		left     Object
+		right    Object
+		expected string
+	}{
+		{token.Plus, &Integer{Value: math.MaxInt64}, &Integer{Value: 1}, "9223372036854775808"},
+		{token.Minus, &Integer{Value: math.MinInt64}, &Integer{Value: 1}, "-9223372036854775809"},
+		{token.Asterisk, &Integer{Value: 1 << 32}, &Integer{Value: 1 << 32}, "18446744073709551616"},
+		{token.Asterisk, &Integer{Value: -1}, &Integer{Value: math.MinInt64}, "9223372036854775808"},
+		{token.Slash, &Integer{Value: math.MinInt64}, &Integer{Value: -1}, "9223372036854775808"},
+		{token.ShiftLeft, &Integer{Value: 3}, &Integer{Value: 62}, "13835058055282163712"},
+		{token.ShiftRight, &Integer{Value: -8}, &Integer{Value: 100}, "-1"},
+		{token.Percent, &Integer{Value: -7}, &Integer{Value: 3}, "-1"},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerInfix(tt.operator, tt.left, tt.right)
+		if err != nil {
+			t.Fatalf("%s %s %s returned error: %s", tt.left.Inspect(), tt.operator, tt.right.Inspect(), err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s %s %s. want=%s, got=%s",
+				tt.left.Inspect(), tt.operator, tt.right.Inspect(), tt.expected, result.Inspect())
+		}
+
+		// the result is the same when computed with big integers
+		left := &BigInteger{Value: bigInt(tt.left)}
+		result, err = IntegerInfix(tt.operator, left, tt.right)
+		if err != nil || result.Inspect() != tt.expected {
+			t.Errorf("wrong big result for %s %s %s. want=%s, got=%v (%v)",
+				tt.left.Inspect(), tt.operator, tt.right.Inspect(), tt.expected, result, err)
+		}
+	}
+
+	huge := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)}
+	result, err := IntegerInfix(token.Minus, huge, huge)
+	if integer, ok := result.(*Integer); err != nil || !ok || integer.Value != 0 {
+		t.Errorf("result not demoted to Integer. got=%#v (%v)", result, err)
+	}
+
+	invalid := []struct {
+		operator string
+		right    Object
+		expected string
+	}{
+		{token.Slash, &Integer{Value: 0}, "division by zero"},
+		{token.Percent, &Integer{Value: 0}, "division by zero"},
+		{token.ShiftLeft, &Integer{Value: -1}, "negative shift count: -1"},
+		{token.ShiftLeft, &Integer{Value: 1 << 40}, "shift count too large: 1099511627776"},
+	}
+
+	for _, tt := range invalid {
+		_, err := IntegerInfix(tt.operator, huge, tt.right)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.operator, tt.expected, err)
+		}
+	}
+
+	negated := NegateInteger(&Integer{Value: math.MinInt64})
+	if negated.Inspect() != "9223372036854775808" {
+		t.Errorf("wrong negation. got=%s", negated.Inspect())
+	}
+
+	if !Equal(huge, &Float{Value: 1 << 64}) || Equal(huge, &Float{Value: math.Inf(1)}) {
+		t.Errorf("wrong equality between %s and floats", huge.Inspect())
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect() for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	r := NewBuiltinRegistry()
+	a := &Builtin{}
+	b := &Builtin{}
+	c := &Builtin{}
+
+	r.Register("a", a)
+	r.Register("b", b)
+	r.Register("c", c)
+	r.Remove("b")
+	r.Register("a", c)
+
+	if index, ok := r.Index("c"); !ok || index != 2 {
+		t.Errorf("wrong index of c. want=2, got=%d (%t)", index, ok)
+	}
+
+	if _, ok := r.Lookup("b"); ok {
+		t.Errorf("removed builtin b is still available")
+	}
+
+	if builtin, ok := r.At(0); !ok || builtin != c {
+		t.Errorf("a was not replaced. got=%p (%t)", builtin, ok)
+	}
+
+	if _, ok := r.At(1); ok {
+		t.Errorf("removed builtin b is still available by index")
+	}
+
+	if _, ok := r.At(3); ok {
+		t.Errorf("out of range index is available")
+	}
+
+	names := r.Names()
+	if len(names) != 2 || names[0] != "a" || names[1] != "c" {
+		t.Errorf("wrong names. got=%v", names)
+	}
+
+	r.Register("b", b)
+	if builtin, ok := r.Lookup("b"); !ok || builtin != b {
+		t.Errorf("b was not registered again. got=%p (%t)", builtin, ok)
+	}
+
+	for i := len(r.builtins); i < MaxBuiltins; i++ {
+		if err := r.Register(fmt.Sprintf("f%d", i), a); err != nil {
+			t.Fatalf("Register failed with %d builtins: %s", i, err)
+		}
+	}
+
+	err := r.Register("full", a)
+	if err == nil || err.Error() != "cannot register full: registry holds 256 builtins" {
+		t.Errorf("wrong error for a full registry. got=%v", err)
+	}
+
+	if err := r.Register("a", b); err != nil {
+		t.Errorf("replacing a builtin of a full registry failed: %s", err)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []Object
+		stdin          string
+		expected       string
+		expectedStdout string
+	}{
+		{BuiltinFuncNamePuts, []Object{&String{Value: "a"}, &Integer{Value: 1}}, "", "", "a\n1\n"},
+		{BuiltinFuncNamePrint, []Object{&String{Value: "a"}, &Integer{Value: 1}}, "", "", "a 1"},
+		{BuiltinFuncNamePrintf, []Object{&String{Value: "%s=%03d %.1f %t %v"}, &String{Value: "x"}, &Integer{Value: 7}, &Float{Value: 2.25}, TRUE, &Array{Elements: []Object{&Integer{Value: 1}}}}, "", "", "x=007 2.2 true [1]"},
+		{BuiltinFuncNamePrintf, []Object{&Integer{Value: 1}}, "", `ERROR: argument to "printf" must be String, got Integer`, ""},
+		{BuiltinFuncNamePrintf, []Object{}, "", "ERROR: wrong number of arguments. got=0, want=1 or more", ""},
+		{BuiltinFuncNameSprintf, []Object{&String{Value: "%d%%"}, &Integer{Value: 50}}, "", "50%", ""},
+		{BuiltinFuncNameReadLine, []Object{}, "first\r\nsecond", "first", ""},
+		{BuiltinFuncNameReadLine, []Object{&String{Value: "> "}}, "last", "last", "> "},
+		{BuiltinFuncNameReadLine, []Object{}, "", "<nil>", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		ctx := NewBuiltinContext(strings.NewReader(tt.stdin), &stdout, &stderr)
+
+		result := getBuiltinByName(tt.name).Fn(ctx, tt.args...)
+
+		inspected := "<nil>"
+		if result != nil {
+			inspected = result.Inspect()
+		}
+		if tt.expected != "" && inspected != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.name, tt.expected, inspected)
+		}
+
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("%s: wrong stdout. want=%q, got=%q", tt.name, tt.expectedStdout, stdout.String())
+		}
+	}
+}
+
+func TestReadLineSequence(t *testing.T) {
+	ctx := NewBuiltinContext(strings.NewReader("a\nb\n"), &bytes.Buffer{}, &bytes.Buffer{})
+	readLine := getBuiltinByName(BuiltinFuncNameReadLine)
+
+	for _, expected := range []string{"a", "b"} {
+		result, ok := readLine.Fn(ctx).(*String)
+		if !ok || result.Value != expected {
+			t.Errorf("wrong line. want=%q, got=%v", expected, result)
+		}
+	}
+
+	if result := readLine.Fn(ctx); result != nil {
+		t.Errorf("expected nil at end of input. got=%v", result)
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	type point struct {
+		X      int `monkey:"x"`
+		Y      int
+		Hidden string `monkey:"-"`
+		secret int
+	}
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(7), "7"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{big.NewInt(5), "5"},
+		{2.5, "2.5"},
+		{"monkey", "monkey"},
+		{[]byte("bytes"), "bytes"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"one": 1}, "{one: 1}"},
+		{map[[2]int]string{{2, 1}: "b", {1, 2}: "a"}, "{[1, 2]: a, [2, 1]: b}"},
+		{point{X: 1, Y: 2, Hidden: "h"}, ""},
+		{&point{X: 1}, ""},
+		{(*point)(nil), "null"},
+		{&Integer{Value: 5}, "5"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Fatalf("FromGo(%#v) returned error: %s", tt.input, err)
+		}
+		if tt.expected != "" && obj.Inspect() != tt.expected {
+			t.Errorf("wrong object for %#v. want=%q, got=%q", tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	obj, err := FromGo(point{X: 1, Y: 2, Hidden: "h"})
+	if err != nil {
+		t.Fatalf("FromGo returned error: %s", err)
+	}
+	hash, ok := obj.(*Hash)
+	if !ok {
+		t.Fatalf("object is not Hash. got=%T (%+v)", obj, obj)
+	}
+	if hash.Inspect() != "{x: 1, Y: 2}" {
+		t.Errorf("wrong hash. want=%q, got=%q", "{x: 1, Y: 2}", hash.Inspect())
+	}
+
+	invalid := []interface{}{
+		make(chan int),
+		map[interface{}]int{struct{}{}: 1},
+		func() (int, int) { return 0, 0 },
+	}
+
+	for _, input := range invalid {
+		_, err := FromGo(input)
+		if err == nil {
+			t.Errorf("FromGo(%T) returned no error", input)
+		}
+	}
+}
+
+func TestFromGoCycles(t *testing.T) {
+	type node struct {
+		Value int
+		Next  *node
+	}
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	n := &node{Value: 1}
+	n.Next = &node{Value: 2, Next: n}
+	sl := []interface{}{1, nil}
+	sl[1] = sl
+
+	for _, input := range []interface{}{m, n, sl} {
+		_, err := FromGo(input)
+		if err == nil || !strings.HasSuffix(err.Error(), "unsupported cyclic value") {
+			t.Errorf("wrong error for cyclic %T. got=%v", input, err)
+		}
+	}
+
+	shared := []int{1}
+	leaf := &node{Value: 3}
+	obj, err := FromGo(map[string]interface{}{"a": shared, "b": shared, "c": []*node{leaf, leaf}})
+	if err != nil {
+		t.Fatalf("FromGo returned error for shared values: %s", err)
+	}
+	expected := "{a: [1], b: [1], c: [{Value: 3, Next: null}, {Value: 3, Next: null}]}"
+	if obj.Inspect() != expected {
+		t.Errorf("wrong hash. want=%q, got=%q", expected, obj.Inspect())
+	}
+}
+
+func TestFromGoFunc(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		args     []Object
+		expected string
+	}{
+		{
+			func(a, b int) int { return a + b },
+			[]Object{&Integer{Value: 1}, &Integer{Value: 2}},
+			"3",
+		},
+		{
+			func(words ...string) string { return strings.Join(words, "-") },
+			[]Object{&String{Value: "a"}, &String{Value: "b"}},
+			"a-b",
+		},
+		{
+			func(n int) (int, error) { return 0, errors.New("failed") },
+			[]Object{&Integer{Value: 1}},
+			"ERROR: failed",
+		},
+		{
+			func(n int8) int8 { return n },
+			[]Object{&Integer{Value: 300}},
+			"ERROR: argument 1: 300 overflows int8",
+		},
+		{
+			func(s string) string { return s },
+			[]Object{&Integer{Value: 1}},
+			"ERROR: argument 1: cannot convert Integer to string",
+		},
+		{
+			func(a, b int) int { return a + b },
+			[]Object{&Integer{Value: 1}},
+			"ERROR: wrong number of arguments. got=1, want=2",
+		},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.fn)
+		if err != nil {
+			t.Fatalf("FromGo(%T) returned error: %s", tt.fn, err)
+		}
+		builtin, ok := obj.(*Builtin)
+		if !ok {
+			t.Fatalf("object is not Builtin. got=%T (%+v)", obj, obj)
+		}
+
+		result := builtin.Fn(DefaultBuiltinContext(), tt.args...)
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %T. want=%q, got=%q", tt.fn, tt.expected, result.Inspect())
+		}
+	}
+
+	obj, _ := FromGo(func() {})
+	if result := obj.(*Builtin).Fn(DefaultBuiltinContext()); result != nil {
+		t.Errorf("func without results returned %+v", result)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	type config struct {
+		Name  string   `monkey:"name"`
+		Ports []uint16 `monkey:"ports"`
+		Debug *bool
+	}
+
+	obj, err := FromGo(map[string]interface{}{
+		"name":  "server",
+		"ports": []int{80, 443},
+		"Debug": true,
+		"other": 1,
+	})
+	if err != nil {
+		t.Fatalf("FromGo returned error: %s", err)
+	}
+
+	var c config
+	err = ToGo(obj, &c)
+	if err != nil {
+		t.Fatalf("ToGo returned error: %s", err)
+	}
+	if c.Name != "server" || len(c.Ports) != 2 || c.Ports[1] != 443 || c.Debug == nil || !*c.Debug {
+		t.Errorf("wrong struct. got=%+v", c)
+	}
+
+	var m map[string]int
+	h := &Hash{}
+	h.Set(&String{Value: "a"}, &Integer{Value: 1})
+	err = ToGo(h, &m)
+	if err != nil || m["a"] != 1 {
+		t.Errorf("wrong map. got=%v (%v)", m, err)
+	}
+
+	var f float64
+	if err := ToGo(&Integer{Value: 2}, &f); err != nil || f != 2 {
+		t.Errorf("wrong float. got=%v (%v)", f, err)
+	}
+
+	huge := &BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 63)}
+	var n *big.Int
+	if err := ToGo(huge, &n); err != nil || n.Cmp(huge.Value) != 0 || n == huge.Value {
+		t.Errorf("wrong big.Int. got=%v (%v)", n, err)
+	}
+
+	var u uint64
+	if err := ToGo(huge, &u); err != nil || u != 1<<63 {
+		t.Errorf("wrong uint64. got=%v (%v)", u, err)
+	}
+
+	var b []byte
+	if err := ToGo(&String{Value: "hi"}, &b); err != nil || string(b) != "hi" {
+		t.Errorf("wrong bytes. got=%q (%v)", b, err)
+	}
+
+	var array *Array
+	input := &Array{Elements: []Object{NULL}}
+	if err := ToGo(input, &array); err != nil || array != input {
+		t.Errorf("object not stored unchanged. got=%v (%v)", array, err)
+	}
+
+	var any interface{}
+	err = ToGo(&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}, NULL, TRUE}}, &any)
+	if err != nil {
+		t.Fatalf("ToGo returned error: %s", err)
+	}
+	if !reflect.DeepEqual(any, []interface{}{int64(1), "a", nil, true}) {
+		t.Errorf("wrong interface value. got=%#v", any)
+	}
+
+	invalid := []struct {
+		obj      Object
+		target   interface{}
+		expected string
+	}{
+		{&Integer{Value: 1}, nil, "target must be a non-nil pointer, got <nil>"},
+		{&Integer{Value: 1}, 0, "target must be a non-nil pointer, got int"},
+		{&Integer{Value: -1}, new(uint), "-1 overflows uint"},
+		{&BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 63)}, new(int64), "9223372036854775808 overflows int64"},
+		{&Float{Value: 1}, new(big.Int), "cannot convert Float to big.Int"},
+		{&String{Value: "a"}, new(int), "cannot convert String to int"},
+		{&Array{Elements: []Object{TRUE}}, new([]int), "index 0: cannot convert Boolean to int"},
+		{&Array{Elements: []Object{TRUE}}, new([2]bool), "cannot convert Array of length 1 to [2]bool"},
+	}
+
+	for _, tt := range invalid {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil {
+			t.Errorf("ToGo(%s, %T) returned no error", tt.obj.Inspect(), tt.target)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	hash := &Hash{}
+	hash.Set(&String{Value: "b"}, &Array{Elements: []Object{&Integer{Value: 1}, &Float{Value: 2}, NULL}})
+	hash.Set(&String{Value: "a"}, &String{Value: "<\"x\">"})
+	hash.Set(&Integer{Value: 3}, TRUE)
+
+	tests := []struct {
+		name     string
+		args     []Object
+		expected string
+	}{
+		{BuiltinFuncNameJSONEncode, []Object{hash}, `{"b":[1,2.0,null],"a":"<\"x\">","3":true}`},
+		{BuiltinFuncNameJSONEncode, []Object{&Array{Elements: []Object{&Integer{Value: 1}}}, TRUE}, "[\n  1\n]"},
+		{BuiltinFuncNameJSONEncode, []Object{&Array{Elements: []Object{}}}, "[]"},
+		{BuiltinFuncNameJSONEncode, []Object{hash, TRUE}, "{\n  \"b\": [\n    1,\n    2.0,\n    null\n  ],\n  \"a\": \"<\\\"x\\\">\",\n  \"3\": true\n}"},
+		{BuiltinFuncNameJSONEncode, []Object{&Builtin{}}, `ERROR: json_encode: unsupported type Builtin`},
+		{BuiltinFuncNameJSONEncode, []Object{&Float{Value: math.Inf(1)}}, `ERROR: json_encode: unsupported float value +Inf`},
+		{BuiltinFuncNameJSONEncode, []Object{NULL, &Integer{Value: 1}}, `ERROR: argument to "json_encode" must be Boolean, got Integer`},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: `{"a": [1, 2.5, 1e2, "s", true, null], "b": {}}`}}, ""},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: `[1, 2.5, 1e2, "s", true, null, 99999999999999999999]`}}, `[1, 2.5, 100.0, s, true, null, 99999999999999999999]`},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: `[1, 2`}}, `ERROR: json_decode: unexpected end of JSON input at offset 5`},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: `[1, x]`}}, `ERROR: json_decode: invalid character 'x' looking for beginning of value at offset 5`},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: `1 2`}}, `ERROR: json_decode: unexpected data after top-level value at offset 3`},
+		{BuiltinFuncNameJSONDecode, []Object{&String{Value: ``}}, `ERROR: json_decode: unexpected end of JSON input`},
+		{BuiltinFuncNameJSONDecode, []Object{&Integer{Value: 1}}, `ERROR: argument to "json_decode" must be String, got Integer`},
+	}
+
+	for _, tt := range tests {
+		result := getBuiltinByName(tt.name).Fn(DefaultBuiltinContext(), tt.args...)
+		if tt.expected != "" && result.Inspect() != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.name, tt.expected, result.Inspect())
+		}
+	}
+
+	decoded := getBuiltinByName(BuiltinFuncNameJSONDecode).Fn(DefaultBuiltinContext(), &String{Value: `{"b": {"c": null}, "a": [1, 2.5]}`})
+	encoded := getBuiltinByName(BuiltinFuncNameJSONEncode).Fn(DefaultBuiltinContext(), decoded)
+	if encoded.Inspect() != `{"b":{"c":null},"a":[1,2.5]}` {
+		t.Errorf("wrong round trip. got=%q", encoded.Inspect())
+	}
+}
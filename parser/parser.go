@@ -1,8 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/kitasuke/monkey-go/ast"
 	"github.com/kitasuke/monkey-go/lexer"
@@ -12,26 +15,46 @@ import (
 const (
 	_ int = iota
 	Lowest
+	LogicalOr     // ||
+	LogicalAnd    // &&
 	Equals        // =
 	LessOrGreater // < or >
-	Sum           // +
-	Product       // *
-	Prefix        // -X or !X
+	Sum           // + or | or ^
+	Product       // * or % or & or << or >>
+	Prefix        // -X or !X or ~X
 	Call          // myFunction(X)
 	Index         // array[index]
 )
 
+var assignOperators = map[token.TokenType]bool{
+	token.Assign:         true,
+	token.PlusAssign:     true,
+	token.MinusAssign:    true,
+	token.AsteriskAssign: true,
+	token.SlashAssign:    true,
+}
+
 var precedences = map[token.TokenType]int{
-	token.Equal:       Equals,
-	token.NotEqual:    Equals,
-	token.LessThan:    LessOrGreater,
-	token.GreaterThan: LessOrGreater,
-	token.Plus:        Sum,
-	token.Minus:       Sum,
-	token.Slash:       Product,
-	token.Asterisk:    Product,
-	token.LeftParen:   Call,
-	token.LeftBracket: Index,
+	token.Or:                 LogicalOr,
+	token.And:                LogicalAnd,
+	token.Equal:              Equals,
+	token.NotEqual:           Equals,
+	token.LessThan:           LessOrGreater,
+	token.GreaterThan:        LessOrGreater,
+	token.LessThanOrEqual:    LessOrGreater,
+	token.GreaterThanOrEqual: LessOrGreater,
+	token.Plus:               Sum,
+	token.Minus:              Sum,
+	token.Pipe:               Sum,
+	token.Caret:              Sum,
+	token.Slash:              Product,
+	token.Asterisk:           Product,
+	token.Percent:            Product,
+	token.Ampersand:          Product,
+	token.ShiftLeft:          Product,
+	token.ShiftRight:         Product,
+	token.LeftParen:          Call,
+	token.LeftBracket:        Index,
 }
 
 type (
@@ -39,9 +62,33 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+// Error is a syntax error spanning the source range [Pos, End).
+type Error struct {
+	Pos     token.Position
+	End     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// bailout is used as a panic value to abandon the statement being parsed
+// after a syntax error has been recorded.
+type bailout struct{}
+
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []*Error
+
+	// number of nested block statements being parsed
+	blockDepth int
+	// brackets opened and not yet closed before currentToken, innermost
+	// last
+	brackets []token.TokenType
+	// number of nested loops around the current statement, reset inside
+	// function literals
+	loopDepth int
 
 	currentToken token.Token
 	peekToken    token.Token
@@ -51,13 +98,15 @@ type Parser struct {
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []*Error{}}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.Identifier, p.parseIdentifier)
 	p.registerPrefix(token.Int, p.parseIntegerLiteral)
+	p.registerPrefix(token.Float, p.parseFloatLiteral)
 	p.registerPrefix(token.Bang, p.parsePrefixExpression)
 	p.registerPrefix(token.Minus, p.parsePrefixExpression)
+	p.registerPrefix(token.Tilde, p.parsePrefixExpression)
 	p.registerPrefix(token.True, p.parseBoolean)
 	p.registerPrefix(token.False, p.parseBoolean)
 	p.registerPrefix(token.LeftParen, p.parseGroupedExpression)
@@ -66,6 +115,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.String, p.parseStringLiteral)
 	p.registerPrefix(token.LeftBracket, p.parseArrayLiteral)
 	p.registerPrefix(token.LeftBrace, p.parseHashLiteral)
+	p.registerPrefix(token.Illegal, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.Plus, p.parseInfixExpression)
@@ -76,6 +126,16 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NotEqual, p.parseInfixExpression)
 	p.registerInfix(token.LessThan, p.parseInfixExpression)
 	p.registerInfix(token.GreaterThan, p.parseInfixExpression)
+	p.registerInfix(token.LessThanOrEqual, p.parseInfixExpression)
+	p.registerInfix(token.GreaterThanOrEqual, p.parseInfixExpression)
+	p.registerInfix(token.And, p.parseInfixExpression)
+	p.registerInfix(token.Or, p.parseInfixExpression)
+	p.registerInfix(token.Percent, p.parseInfixExpression)
+	p.registerInfix(token.Ampersand, p.parseInfixExpression)
+	p.registerInfix(token.Pipe, p.parseInfixExpression)
+	p.registerInfix(token.Caret, p.parseInfixExpression)
+	p.registerInfix(token.ShiftLeft, p.parseInfixExpression)
+	p.registerInfix(token.ShiftRight, p.parseInfixExpression)
 	p.registerInfix(token.LeftParen, p.parseCallExpression)
 	p.registerInfix(token.LeftBracket, p.parseIndexExpression)
 
@@ -91,28 +151,60 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.currentToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
+		stmt, ok := p.parseStatementWithRecovery()
+		if ok {
 			program.Statements = append(program.Statements, stmt)
+			p.nextToken()
 		}
-		p.nextToken()
 	}
 
 	return program
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []*Error {
 	return p.errors
 }
 
 // Statements
 
+// parseStatementWithRecovery parses a single statement. If it contains a
+// syntax error, the statement is dropped, the parser is resynchronised at
+// the start of the next statement and ok is false.
+func (p *Parser) parseStatementWithRecovery() (stmt ast.Statement, ok bool) {
+	depth := len(p.brackets)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isBailout := r.(bailout); !isBailout {
+				panic(r)
+			}
+
+			stmt, ok = nil, false
+			p.synchronize(depth)
+		}
+	}()
+
+	return p.parseStatement(), true
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.currentToken.Type {
 	case token.Let:
 		return p.parseLetStatement()
 	case token.Return:
 		return p.parseReturnStatement()
+	case token.While:
+		return p.parseWhileStatement()
+	case token.For:
+		return p.parseForStatement()
+	case token.Break:
+		return p.parseBreakStatement()
+	case token.Continue:
+		return p.parseContinueStatement()
+	case token.Try:
+		return p.parseTryStatement()
+	case token.Throw:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -135,6 +227,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(Lowest)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.Semicolon) {
 		p.nextToken()
 	}
@@ -155,11 +251,209 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.LeftParen) {
+		return nil
+	}
+
+	// get condition
+	p.nextToken()
+	stmt.Condition = p.parseExpression(Lowest)
+
+	if !p.expectPeek(token.RightParen) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LeftBrace) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() *ast.ForStatement {
+	stmt := &ast.ForStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.LeftParen) {
+		return nil
+	}
+
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(token.In) {
+		return nil
+	}
+
+	// get iterable
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(Lowest)
+
+	if !p.expectPeek(token.RightParen) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LeftBrace) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.currentToken}
+
+	if p.loopDepth == 0 {
+		p.addError(p.currentToken, "break outside loop")
+	}
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.currentToken}
+
+	if p.loopDepth == 0 {
+		p.addError(p.currentToken, "continue outside loop")
+	}
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.currentToken}
+
+	if !p.expectPeek(token.LeftBrace) {
+		return nil
+	}
+
+	stmt.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.Catch) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LeftParen) {
+			return nil
+		}
+
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
+
+		stmt.CatchParam = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+		if !p.expectPeek(token.RightParen) {
+			return nil
+		}
+
+		if !p.expectPeek(token.LeftBrace) {
+			return nil
+		}
+
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.Finally) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LeftBrace) {
+			return nil
+		}
+
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.addError(p.peekToken, "expected catch or finally after try block")
+	}
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.currentToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(Lowest)
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.currentToken}
 
 	stmt.Expression = p.parseExpression(Lowest)
 
+	if assignOperators[p.peekToken.Type] {
+		return p.parseAssignStatement(stmt.Expression)
+	}
+
+	if p.peekTokenIs(token.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAssignStatement(target ast.Expression) *ast.AssignStatement {
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		msg := fmt.Sprintf("cannot assign to %s", target.String())
+		p.addError(p.peekToken, msg)
+	}
+
+	p.nextToken()
+	stmt := &ast.AssignStatement{
+		Token:    p.currentToken,
+		Target:   target,
+		Operator: p.currentToken.Literal,
+	}
+
+	// get value
+	p.nextToken()
+	stmt.Value = p.parseExpression(Lowest)
+
 	if p.peekTokenIs(token.Semicolon) {
 		p.nextToken()
 	}
@@ -197,13 +491,50 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 }
 
+func (p *Parser) parseIllegal() ast.Expression {
+	literal := p.currentToken.Literal
+
+	var msg string
+	switch {
+	case strings.HasPrefix(literal, `"`):
+		msg = "unterminated string literal"
+	case strings.HasPrefix(literal, "/*"):
+		msg = "unterminated comment"
+	default:
+		msg = fmt.Sprintf("illegal character %q", literal)
+	}
+
+	p.addError(p.currentToken, msg)
+	return nil
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.currentToken}
 
 	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if n, ok := new(big.Int).SetString(p.currentToken.Literal, 0); ok {
+			lit.Big = n
+			return lit
+		}
+	}
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.currentToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.currentToken, msg)
+		return nil
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.currentToken}
+
+	value, err := strconv.ParseFloat(p.currentToken.Literal, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as float", p.currentToken.Literal)
+		p.addError(p.currentToken, msg)
 		return nil
 	}
 
@@ -279,16 +610,21 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.currentToken}
 	block.Statements = []ast.Statement{}
 
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
+
 	p.nextToken()
 
 	for !p.currentTokenIs(token.RightBrace) && !p.currentTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
+		stmt, ok := p.parseStatementWithRecovery()
+		if ok {
 			block.Statements = append(block.Statements, stmt)
+			p.nextToken()
 		}
-		p.nextToken()
 	}
 
+	block.Rbrace = p.currentToken.Pos
+
 	return block
 }
 
@@ -305,6 +641,11 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	// break and continue cannot cross function boundaries
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = loopDepth }()
+
 	lit.Body = p.parseBlockStatement()
 
 	return lit
@@ -319,14 +660,18 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return identifiers
 	}
 
-	p.nextToken()
+	if !p.expectPeek(token.Identifier) {
+		return nil
+	}
 
 	identifier := &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 	identifiers = append(identifiers, identifier)
 
 	for p.peekTokenIs(token.Comma) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.Identifier) {
+			return nil
+		}
 		identifier := &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 		identifiers = append(identifiers, identifier)
 	}
@@ -346,6 +691,7 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.currentToken}
 
 	array.Elements = p.parseExpressionList(token.RightBracket)
+	array.Rbracket = p.currentToken.Pos
 
 	return array
 }
@@ -383,13 +729,13 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectPeek(token.RightBracket) {
 		return nil
 	}
+	exp.Rbracket = p.currentToken.Pos
 
 	return exp
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.currentToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 
 	for !p.peekTokenIs(token.RightBrace) {
 		p.nextToken()
@@ -402,7 +748,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		value := p.parseExpression(Lowest)
 
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
 		if !p.peekTokenIs(token.RightBrace) && !p.expectPeek(token.Comma) {
 			return nil
@@ -412,6 +758,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	if !p.expectPeek(token.RightBrace) {
 		return nil
 	}
+	hash.Rbrace = p.currentToken.Pos
 
 	return hash
 }
@@ -434,14 +781,30 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.currentToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RightParen)
+	exp.Rparen = p.currentToken.Pos
 	return exp
 }
 
 // Token
 
 func (p *Parser) nextToken() {
+	switch p.currentToken.Type {
+	case token.LeftParen, token.LeftBracket, token.LeftBrace:
+		p.brackets = append(p.brackets, p.currentToken.Type)
+	case token.RightParen, token.RightBracket, token.RightBrace:
+		if len(p.brackets) > 0 {
+			p.brackets = p.brackets[:len(p.brackets)-1]
+		}
+	}
+
 	p.currentToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// comments are only returned by lexers that scan them and carry no
+	// meaning for the parser
+	for p.peekToken.Type == token.Comment {
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -482,14 +845,66 @@ func (p *Parser) currentPrecedence() int {
 
 // Error
 
+// addError records a syntax error at tok and abandons the current statement.
+func (p *Parser) addError(tok token.Token, msg string) {
+	p.errors = append(p.errors, &Error{Pos: tok.Pos, End: tok.End, Message: msg})
+	panic(bailout{})
+}
+
+// synchronize skips tokens after a syntax error until a statement boundary
+// (`;`, `let`, `return`, or the `}` closing the enclosing block), leaving
+// currentToken on the first token of the next statement. The statement
+// started with depth brackets open; boundaries inside the braces it opened,
+// such as those of function literals, are skipped with the braces. Parens
+// and brackets cannot contain statements, so a boundary inside them means
+// they were left unclosed.
+func (p *Parser) synchronize(depth int) {
+	for skipped := false; !p.currentTokenIs(token.EOF); skipped = true {
+		if n := len(p.brackets); n > depth && p.brackets[n-1] == token.LeftBrace {
+			p.skipToken(depth)
+			continue
+		}
+
+		switch p.currentToken.Type {
+		case token.Semicolon:
+			p.brackets = p.brackets[:depth]
+			p.nextToken()
+			return
+		case token.RightBrace:
+			if p.blockDepth > 0 {
+				p.brackets = p.brackets[:depth]
+				return
+			}
+		case token.Let, token.Return:
+			if skipped {
+				p.brackets = p.brackets[:depth]
+				return
+			}
+		}
+
+		p.skipToken(depth)
+	}
+}
+
+// skipToken advances past currentToken while synchronizing, ignoring stray
+// closing brackets that would close the depth brackets open before the
+// statement.
+func (p *Parser) skipToken(depth int) {
+	brackets := p.brackets
+	p.nextToken()
+	if len(p.brackets) < depth {
+		p.brackets = brackets
+	}
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, msg)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.currentToken, msg)
 }
 
 // Operators
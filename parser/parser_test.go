@@ -6,6 +6,7 @@ import (
 
 	"github.com/kitasuke/monkey-go/ast"
 	"github.com/kitasuke/monkey-go/lexer"
+	"github.com/kitasuke/monkey-go/token"
 )
 
 func TestLetStatement(t *testing.T) {
@@ -38,6 +39,43 @@ func TestLetStatement(t *testing.T) {
 	}
 }
 
+func TestAssignStatement(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedOperator string
+		expectedString   string
+	}{
+		{"x = 5;", "=", "x = 5;"},
+		{"x += y * 2", "+=", "x += (y * 2);"},
+		{"x -= 1", "-=", "x -= 1;"},
+		{"x *= 1", "*=", "x *= 1;"},
+		{"x /= 1", "/=", "x /= 1;"},
+		{`arr[0] = 1`, "=", "(arr[0]) = 1;"},
+		{`h["k"] += v`, "+=", "(h[k]) += v;"},
+	}
+
+	for _, tt := range tests {
+		program := createParseProgram(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.AssignStatement{}, program.Statements[0])
+		}
+
+		if stmt.Operator != tt.expectedOperator {
+			t.Errorf("stmt.Operator is not %q. got=%q", tt.expectedOperator, stmt.Operator)
+		}
+
+		if stmt.String() != tt.expectedString {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expectedString, stmt.String())
+		}
+	}
+}
+
 func TestReturnStatement(t *testing.T) {
 	input := `
 		return 5;
@@ -123,6 +161,63 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := "18446744073709551616;"
+
+	program := createParseProgram(input, t)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.ExpressionStatement{}, program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not %T. got=%T", &ast.IntegerLiteral{}, stmt.Expression)
+	}
+
+	if literal.Big == nil || literal.Big.String() != "18446744073709551616" {
+		t.Errorf("literal.Big not %s. got=%v", "18446744073709551616", literal.Big)
+	}
+
+	if literal.String() != "18446744073709551616" {
+		t.Errorf("literal.String not %s. got=%s", "18446744073709551616", literal.String())
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E3", 2500},
+	}
+
+	for _, tt := range tests {
+		program := createParseProgram(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.ExpressionStatement{}, program.Statements[0])
+		}
+
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not %T. got=%T", &ast.FloatLiteral{}, stmt.Expression)
+		}
+
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -133,6 +228,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 		{"-15", "-", 15},
 		{"!true", "!", true},
 		{"!false", "!", false},
+		{"~15", "~", 15},
 	}
 
 	for _, tt := range prefixTests {
@@ -175,6 +271,12 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
@@ -239,6 +341,30 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"5 > 4 != 3 < 4",
 			"((5 > 4) != (3 < 4))",
 		},
+		{
+			"5 >= 4 == 3 <= 4 + 1",
+			"((5 >= 4) == (3 <= (4 + 1)))",
+		},
+		{
+			"a + b % c << d",
+			"(a + ((b % c) << d))",
+		},
+		{
+			"a | b ^ c & d == e",
+			"(((a | b) ^ (c & d)) == e)",
+		},
+		{
+			"~a * b",
+			"((~a) * b)",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c && d",
+			"((a && b) || ((!c) && d))",
+		},
 		{
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
@@ -408,6 +534,130 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { if (x == 5) { break; } continue; }`
+
+	program := createParseProgram(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.WhileStatement{}, program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Fatalf("Statements[1] is not %T. got=%T", &ast.ContinueStatement{}, stmt.Body.Statements[1])
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { puts(x); }`
+
+	program := createParseProgram(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.ForStatement{}, program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+
+	if stmt.Iterable.String() != "[1, 2]" {
+		t.Errorf("iterable is not %q. got=%q", "[1, 2]", stmt.Iterable.String())
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if stmt.String() != "for(x in [1, 2]) puts(x)" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedParam string
+		hasCatch      bool
+		hasFinally    bool
+		expected      string
+	}{
+		{`try { f(); } catch (e) { g(e); }`, "e", true, false, "try f() catch(e) g(e)"},
+		{`try { f(); } finally { g(); }`, "", false, true, "try f() finally g()"},
+		{`try { f(); } catch (err) { 1 } finally { 2 }`, "err", true, true, "try f() catch(err) 1 finally 2"},
+	}
+
+	for _, tt := range tests {
+		program := createParseProgram(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.TryStatement{}, program.Statements[0])
+		}
+
+		if (stmt.Catch != nil) != tt.hasCatch {
+			t.Errorf("stmt.Catch wrong. want catch=%t, got=%v", tt.hasCatch, stmt.Catch)
+		}
+
+		if tt.hasCatch && !testIdentifier(t, stmt.CatchParam, tt.expectedParam) {
+			return
+		}
+
+		if (stmt.Finally != nil) != tt.hasFinally {
+			t.Errorf("stmt.Finally wrong. want finally=%t, got=%v", tt.hasFinally, stmt.Finally)
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw x + 1;`
+
+	program := createParseProgram(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.ThrowStatement{}, program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Value, "x", "+", 1) {
+		return
+	}
+
+	if stmt.String() != "throw (x + 1);" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -446,6 +696,30 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	program := createParseProgram(input, t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n", 1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", &ast.LetStatement{}, program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not %T. got=%T", &ast.FunctionLiteral{}, stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n", function.Name)
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -547,15 +821,22 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	expectedKeys := []string{"one", "two", "three"}
+
+	for i, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not %T. got=%T", ast.StringLiteral{}, key)
+			t.Errorf("key is not %T. got=%T", ast.StringLiteral{}, pair.Key)
+			continue
+		}
+
+		if literal.String() != expectedKeys[i] {
+			t.Errorf("key %d is not %q. got=%q", i, expectedKeys[i], literal.String())
 		}
 
 		expectedValue := expected[literal.String()]
 
-		testIntegerLiteral(t, value, expectedValue)
+		testIntegerLiteral(t, pair.Value, expectedValue)
 	}
 }
 
@@ -608,10 +889,10 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for key, value := range hash.Pairs {
-		literal, ok := key.(*ast.StringLiteral)
+	for _, pair := range hash.Pairs {
+		literal, ok := pair.Key.(*ast.StringLiteral)
 		if !ok {
-			t.Errorf("key is not %T. got=%T", ast.StringLiteral{}, key)
+			t.Errorf("key is not %T. got=%T", ast.StringLiteral{}, pair.Key)
 			continue
 		}
 
@@ -621,7 +902,7 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 			continue
 		}
 
-		testFunc(value)
+		testFunc(pair.Value)
 	}
 }
 
@@ -804,6 +1085,216 @@ func testInfixExpression(t *testing.T, exp ast.Expression, left interface{}, ope
 	return true
 }
 
+func TestParsingWithComments(t *testing.T) {
+	input := `// add two numbers
+let add = fn(x, y) { x + y }; /* done */
+add(1, 2) // call`
+
+	for _, mode := range []lexer.Mode{0, lexer.ScanComments} {
+		l := lexer.NewWithMode(input, mode)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		expected := "let add = fn<add>(x, y)(x + y);add(1, 2)"
+		if program.String() != expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", expected, program.String())
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+  x + y;
+};
+add(1, [2, 3])[0]`
+
+	program := createParseProgram(input, t)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "4:18"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:18"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression).Left, "4:1", "4:15"},
+	}
+
+	for i, tt := range tests {
+		if start := tt.node.Pos().String(); start != tt.expectedStart {
+			t.Errorf("tests[%d] - Pos() wrong. expected=%s, got=%s", i, tt.expectedStart, start)
+		}
+		if end := tt.node.End().String(); end != tt.expectedEnd {
+			t.Errorf("tests[%d] - End() wrong. expected=%s, got=%s", i, tt.expectedEnd, end)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := "let x 5;"
+
+	l := lexer.NewWithFilename(input, "test.mk")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	err := errors[0]
+	expectedPos := token.Position{Filename: "test.mk", Offset: 6, Line: 1, Column: 7}
+	if err.Pos != expectedPos {
+		t.Errorf("err.Pos wrong. expected=%+v, got=%+v", expectedPos, err.Pos)
+	}
+
+	expected := "test.mk:1:7: expected next token to be =, got Int instead"
+	if err.Error() != expected {
+		t.Errorf("err.Error() wrong. expected=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestParserErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			`let x 5;
+			let y = 10;
+			let = 3;
+			return y;
+			add(1, 2;
+			let z = 1;`,
+			[]string{
+				"1:7: expected next token to be =, got Int instead",
+				"3:8: expected next token to be Identifier, got = instead",
+				"5:12: expected next token to be ), got ; instead",
+			},
+			[]string{"let y = 10;", "return y;", "let z = 1;"},
+		},
+		{
+			`let f = fn(a) { let = 1; a }; let b = 2;`,
+			[]string{
+				"1:21: expected next token to be Identifier, got = instead",
+			},
+			[]string{"let f = fn<f>(a)a;", "let b = 2;"},
+		},
+		{
+			`if (x) { 1 + } let c = 3; }`,
+			[]string{
+				"1:14: no prefix parse function for } found",
+				"1:27: no prefix parse function for } found",
+			},
+			[]string{"ifx ", "let c = 3;"},
+		},
+		{
+			`break; while (true) { fn() { continue; } }; for (x y) {}`,
+			[]string{
+				"1:1: break outside loop",
+				"1:30: continue outside loop",
+				"1:52: expected next token to be In, got Identifier instead",
+			},
+			[]string{"whiletrue fn()"},
+		},
+		{
+			`1 = 2; f() += 1; x = 3;`,
+			[]string{
+				"1:3: cannot assign to 1",
+				"1:12: cannot assign to f()",
+			},
+			[]string{"x = 3;"},
+		},
+		{
+			`try { 1 } let u = 1; try { 2 } catch e { 3 }`,
+			[]string{
+				"1:11: expected catch or finally after try block",
+				"1:38: expected next token to be (, got Identifier instead",
+			},
+			[]string{"let u = 1;"},
+		},
+		{
+			`let s = "abc; let t = 1;`,
+			[]string{
+				"1:9: unterminated string literal",
+			},
+			[]string{},
+		},
+		{
+			`let d = fn(1) { 1 }; d(`,
+			[]string{
+				"1:12: expected next token to be Identifier, got Int instead",
+				"1:24: no prefix parse function for EOF found",
+			},
+			[]string{},
+		},
+		{
+			`let f = fn() { let x = {1: }; let y = 2; }; let z = 3;`,
+			[]string{
+				"1:28: no prefix parse function for } found",
+			},
+			[]string{"let f = fn<f>()let y = 2;;", "let z = 3;"},
+		},
+		{
+			`let f = 1 + * fn() { let a = 1; let b = 2; }; let c = 3;`,
+			[]string{
+				"1:13: no prefix parse function for * found",
+			},
+			[]string{"let c = 3;"},
+		},
+		{
+			`let h = [{"a": fn() { 1; 2 }, "b": }]; let i = 4;`,
+			[]string{
+				"1:36: no prefix parse function for } found",
+			},
+			[]string{"let i = 4;"},
+		},
+		{
+			`let f = fn() { let a = 1); let b = 2; }; let c = 3;`,
+			[]string{
+				"1:25: no prefix parse function for ) found",
+			},
+			[]string{"let f = fn<f>()let a = 1;let b = 2;;", "let c = 3;"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("wrong number of errors. want=%d, got=%d", len(tt.expectedErrors), len(errors))
+			for _, err := range errors {
+				t.Errorf("parser error: %q", err)
+			}
+			continue
+		}
+
+		for i, expected := range tt.expectedErrors {
+			if errors[i].Error() != expected {
+				t.Errorf("errors[%d] wrong. want=%q, got=%q", i, expected, errors[i].Error())
+			}
+		}
+
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Errorf("wrong number of statements. want=%d, got=%d (%q)", len(tt.expectedStatements), len(program.Statements), program.String())
+			continue
+		}
+
+		for i, expected := range tt.expectedStatements {
+			if program.Statements[i].String() != expected {
+				t.Errorf("statements[%d] wrong. want=%q, got=%q", i, expected, program.Statements[i].String())
+			}
+		}
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
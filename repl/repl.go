@@ -1,7 +1,6 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 
@@ -15,23 +14,21 @@ import (
 const Prompt = ">> "
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	// programs share the input with the REPL, and write to its output
+	context := object.NewBuiltinContext(in, out, out)
 
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalSize)
-	symbolTable := compiler.NewSymbolTable()
-	for i, v := range object.Builtins {
-		symbolTable.DefineBuiltin(i, v.Name)
-	}
+	builtins := object.DefaultBuiltins()
+	symbolTable := compiler.NewSymbolTableWithBuiltins(builtins)
 
 	for {
-		fmt.Printf(Prompt)
-		scanned := scanner.Scan()
-		if !scanned {
+		fmt.Fprint(out, Prompt)
+		line, ok, err := context.ReadLine()
+		if !ok || err != nil {
 			return
 		}
 
-		line := scanner.Text()
 		l := lexer.New(line)
 		p := parser.New(l)
 		program := p.ParseProgram()
@@ -42,7 +39,7 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		err = comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation fialed:\n %s\n", err)
 			continue
@@ -52,20 +49,29 @@ func Start(in io.Reader, out io.Writer) {
 		constants = code.Constants
 
 		machine := vm.NewWithGlobalsStore(code, globals)
+		machine.SetBuiltins(builtins)
+		machine.SetBuiltinContext(context)
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode fialed:\n %s\n", err)
+			if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+				io.WriteString(out, runtimeErr.StackTrace())
+			}
 			continue
 		}
 
+		// input without statements, such as a comment, leaves no value
 		lastPopped := machine.LastPoppedStackElem()
+		if lastPopped == nil {
+			continue
+		}
 		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 	}
 }
 
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func printParseErrors(out io.Writer, errors []*parser.Error) {
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
 	}
 }
@@ -1,19 +1,50 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the token
+}
+
+// Position describes a location in the source. Line and Column are 1-based,
+// Offset is the 0-based byte offset. A zero Position is invalid.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
 }
 
 const (
 	Illegal = "Illegal"
 	EOF     = "EOF"
+	Comment = "Comment" // only emitted when the lexer scans comments
 
 	// Identifiers + Literals
 	Identifier = "Identifier" // add, x ,y, ...
 	Int        = "Int"        // 123456
+	Float      = "Float"      // 3.14, 1e-9
 	String     = "String"     // "x", "y"
 
 	// Operators
@@ -26,8 +57,26 @@ const (
 	Equal    = "=="
 	NotEqual = "!="
 
-	LessThan    = "<"
-	GreaterThan = ">"
+	Percent    = "%"
+	Ampersand  = "&"
+	Pipe       = "|"
+	Caret      = "^"
+	Tilde      = "~"
+	ShiftLeft  = "<<"
+	ShiftRight = ">>"
+
+	PlusAssign     = "+="
+	MinusAssign    = "-="
+	AsteriskAssign = "*="
+	SlashAssign    = "/="
+
+	LessThan           = "<"
+	GreaterThan        = ">"
+	LessThanOrEqual    = "<="
+	GreaterThanOrEqual = ">="
+
+	And = "&&"
+	Or  = "||"
 
 	// Delimiters
 	Comma     = ","
@@ -49,16 +98,34 @@ const (
 	If       = "If"
 	Else     = "Else"
 	Return   = "Return"
+	While    = "While"
+	For      = "For"
+	In       = "In"
+	Break    = "Break"
+	Continue = "Continue"
+	Try      = "Try"
+	Catch    = "Catch"
+	Finally  = "Finally"
+	Throw    = "Throw"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     Function,
-	"let":    Let,
-	"true":   True,
-	"false":  False,
-	"if":     If,
-	"else":   Else,
-	"return": Return,
+	"fn":       Function,
+	"let":      Let,
+	"true":     True,
+	"false":    False,
+	"if":       If,
+	"else":     Else,
+	"return":   Return,
+	"while":    While,
+	"for":      For,
+	"in":       In,
+	"break":    Break,
+	"continue": Continue,
+	"try":      Try,
+	"catch":    Catch,
+	"finally":  Finally,
+	"throw":    Throw,
 }
 
 func LookupIdentifierType(identifier string) TokenType {
@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kitasuke/monkey-go/object"
+)
+
+// ErrInvalidBytecode is matched by errors.Is when a run is stopped because
+// its bytecode is inconsistent: it fails compiler.Bytecode.Validate, which
+// runs before the bytecode does, or refers to a free variable that does not
+// exist. Bytecode produced by the compiler never is.
+var ErrInvalidBytecode = errors.New("invalid bytecode")
+
+// RuntimeError is returned by Run when an exception is not caught. Stack
+// holds the Monkey call stack where it was raised.
+type RuntimeError struct {
+	Message string
+	Stack   object.StackTrace
+	Err     error // the *object.BudgetError or ErrInvalidBytecode that stopped the run, if any
+}
+
+func (e *RuntimeError) Error() string { return e.Message }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// StackTrace formats the call stack, one frame per line.
+func (e *RuntimeError) StackTrace() string {
+	return e.Stack.String()
+}
+
+// bytecodeError reports an inconsistency of the bytecode found while running
+// it.
+type bytecodeError struct {
+	message string
+}
+
+func (e *bytecodeError) Error() string { return fmt.Sprintf("%s: %s", ErrInvalidBytecode, e.message) }
+func (e *bytecodeError) Unwrap() error { return ErrInvalidBytecode }
+
+func invalidBytecode(format string, a ...interface{}) error {
+	return &bytecodeError{message: fmt.Sprintf(format, a...)}
+}
+
+// thrownError carries an exception raised by OpThrow out of the execution
+// loop.
+type thrownError struct {
+	exception *object.Exception
+}
+
+func (e *thrownError) Error() string { return e.exception.Message }
+
+// stackTrace returns the current call stack, innermost call first.
+func (vm *VM) stackTrace() object.StackTrace {
+	stack := make(object.StackTrace, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		fn := frame.cl.Fn
+
+		stack = append(stack, object.StackFrame{
+			Function: fn.Name,
+			Pos:      fn.Lines.Lookup(frame.ip),
+		})
+	}
+
+	return stack
+}
+
+// throw raises value as an exception. Throwing a caught exception raises it
+// again unchanged.
+func (vm *VM) throw(value object.Object) error {
+	exception, ok := value.(*object.Exception)
+	if !ok {
+		exception = &object.Exception{
+			Message: object.ExceptionMessage(value),
+			Value:   value,
+			Stack:   vm.stackTrace(),
+		}
+	}
+
+	return &thrownError{exception: exception}
+}
+
+// exception converts an error of the execution loop into an exception.
+func (vm *VM) exception(err error) *object.Exception {
+	if thrown, ok := err.(*thrownError); ok {
+		return thrown.exception
+	}
+
+	return &object.Exception{
+		Message: err.Error(),
+		Value:   &object.String{Value: err.Error()},
+		Stack:   vm.stackTrace(),
+	}
+}
+
+// catch transfers control to the innermost exception handler, discarding
+// the frames above it. It reports false if there is no handler.
+func (vm *VM) catch(exception *object.Exception) bool {
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		if len(frame.handlers) == 0 {
+			continue
+		}
+
+		h := frame.handlers[len(frame.handlers)-1]
+		frame.handlers = frame.handlers[:len(frame.handlers)-1]
+
+		vm.framesIndex = i + 1
+		vm.sp = h.sp
+		frame.ip = h.ip - 1
+
+		vm.stack[vm.sp] = exception
+		vm.sp++
+
+		return true
+	}
+
+	return false
+}
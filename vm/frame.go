@@ -9,6 +9,13 @@ type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+	handlers    []handler
+}
+
+// handler is an exception handler installed by OpTry.
+type handler struct {
+	ip int // address of the handler code
+	sp int // stack pointer to restore before running it
 }
 
 func NewFrame(cl *object.Closure, basePointer int) *Frame {
@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/kitasuke/monkey-go/object"
+)
+
+const IteratorObj = "Iterator"
+
+// Iterator walks the values a for loop visits: the elements of an array,
+// the characters of a string or the keys of a hash.
+type Iterator struct {
+	elements []object.Object
+	index    int
+}
+
+func (it *Iterator) Type() object.ObjectType { return IteratorObj }
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("Iterator[%p]", it)
+}
+
+func newIterator(obj object.Object) (*Iterator, error) {
+	it := &Iterator{}
+
+	switch obj := obj.(type) {
+	case *object.Array:
+		it.elements = obj.Elements
+	case *object.String:
+		for _, r := range obj.Value {
+			it.elements = append(it.elements, &object.String{Value: string(r)})
+		}
+	case *object.Hash:
+		for _, pair := range obj.Pairs() {
+			it.elements = append(it.elements, pair.Key)
+		}
+	default:
+		return nil, fmt.Errorf("not iterable: %s", obj.Type())
+	}
+
+	return it, nil
+}
+
+func (it *Iterator) next() (object.Object, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
+	}
+
+	element := it.elements[it.index]
+	it.index++
+	return element, true
+}
@@ -1,20 +1,37 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/kitasuke/monkey-go/code"
 	"github.com/kitasuke/monkey-go/compiler"
 	"github.com/kitasuke/monkey-go/object"
+	"github.com/kitasuke/monkey-go/token"
 )
 
 const StackSize = 2048
 const GlobalSize = 65536
 const MaxFrames = 1024
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
+
+// integerOperators maps the opcodes of integer arithmetic to the operators
+// object.IntegerInfix implements them for.
+var integerOperators = map[code.Opcode]string{
+	code.OpAdd:        token.Plus,
+	code.OpSub:        token.Minus,
+	code.OpMul:        token.Asterisk,
+	code.OpDiv:        token.Slash,
+	code.OpMod:        token.Percent,
+	code.OpBitAnd:     token.Ampersand,
+	code.OpBitOr:      token.Pipe,
+	code.OpBitXor:     token.Caret,
+	code.OpShiftLeft:  token.ShiftLeft,
+	code.OpShiftRight: token.ShiftRight,
+}
 
 type VM struct {
 	constants   []object.Object
@@ -23,10 +40,21 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	budget   object.Budget
+	builtins *object.BuiltinRegistry
+	context  *object.BuiltinContext
+
+	// why the bytecode cannot run, if it is invalid
+	invalid error
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Name:         object.MainFunctionName,
+		Lines:        bytecode.Lines,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
@@ -34,12 +62,15 @@ func New(bytecode *compiler.Bytecode) *VM {
 	frames[0] = mainFrame
 
 	return &VM{
+		invalid:     bytecode.Validate(),
 		constants:   bytecode.Constants,
 		stack:       make([]object.Object, StackSize),
 		sp:          0,
 		globals:     make([]object.Object, GlobalSize),
 		frames:      frames,
 		framesIndex: 1,
+		builtins:    object.DefaultBuiltins(),
+		context:     object.DefaultBuiltinContext(),
 	}
 }
 
@@ -49,7 +80,90 @@ func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// SetBuiltins sets the builtins the bytecode was compiled with.
+func (vm *VM) SetBuiltins(builtins *object.BuiltinRegistry) {
+	vm.builtins = builtins
+}
+
+// SetBuiltinContext sets the context builtins run in.
+func (vm *VM) SetBuiltinContext(context *object.BuiltinContext) {
+	vm.context = context
+}
+
+// SetLimits bounds the resources used by later runs.
+func (vm *VM) SetLimits(limits object.Limits) {
+	vm.budget.Limits = limits
+}
+
+// Run executes the bytecode. Errors that are not caught by the program are
+// returned as *RuntimeError.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext executes the bytecode until it finishes or ctx ends. A run that
+// exceeds the limits or is cancelled fails with a *RuntimeError wrapping
+// object.ErrBudgetExceeded, which the program cannot catch. So does invalid
+// bytecode, with ErrInvalidBytecode.
+func (vm *VM) RunContext(ctx context.Context) error {
+	if vm.invalid != nil {
+		return &RuntimeError{Message: vm.invalid.Error(), Err: ErrInvalidBytecode}
+	}
+
+	vm.budget.Start(ctx)
+	defer vm.budget.Stop()
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		if budgetErr, ok := err.(*object.BudgetError); ok {
+			return &RuntimeError{Message: err.Error(), Stack: vm.stackTrace(), Err: budgetErr}
+		}
+		if _, ok := err.(*bytecodeError); ok {
+			return &RuntimeError{Message: err.Error(), Err: ErrInvalidBytecode}
+		}
+
+		exception := vm.exception(err)
+		if !vm.catch(exception) {
+			return &RuntimeError{Message: exception.Message, Stack: exception.Stack}
+		}
+	}
+}
+
+// CallContext calls fn, a closure or builtin, with args and returns its
+// result. The closure must have been compiled with the constants of vm.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (object.Object, error) {
+	if len(args) > 255 {
+		return nil, fmt.Errorf("too many arguments: %d", len(args))
+	}
+
+	mainFn := &object.CompiledFunction{
+		Instructions: code.Make(code.OpCall, len(args)),
+		Name:         object.MainFunctionName,
+	}
+	vm.frames[0] = NewFrame(&object.Closure{Fn: mainFn}, 0)
+	vm.framesIndex = 1
+	vm.sp = 0
+
+	for _, o := range append([]object.Object{fn}, args...) {
+		err := vm.push(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := vm.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.StackTop(), nil
+}
+
+func (vm *VM) run() error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -57,6 +171,11 @@ func (vm *VM) Run() error {
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
+		err := vm.budget.Step()
+		if err != nil {
+			return err
+		}
+
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
@@ -72,7 +191,8 @@ func (vm *VM) Run() error {
 			}
 		case code.OpPop:
 			vm.pop()
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -87,7 +207,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan:
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterThanOrEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -102,6 +222,11 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case code.OpBitNot:
+			err := vm.executeBitNotOperator()
+			if err != nil {
+				return err
+			}
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
 			vm.currentFrame().ip += 2
@@ -127,7 +252,7 @@ func (vm *VM) Run() error {
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.pushVariable(vm.globals[globalIndex])
 			if err != nil {
 				return err
 			}
@@ -138,7 +263,12 @@ func (vm *VM) Run() error {
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
 
-			err := vm.push(array)
+			err := vm.budget.Allocate(array)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
@@ -152,6 +282,11 @@ func (vm *VM) Run() error {
 			}
 			vm.sp = vm.sp - numElements
 
+			err = vm.budget.Allocate(hash)
+			if err != nil {
+				return err
+			}
+
 			err = vm.push(hash)
 			if err != nil {
 				return err
@@ -175,6 +310,12 @@ func (vm *VM) Run() error {
 		case code.OpReturnValue:
 			returnValue := vm.pop()
 
+			if vm.framesIndex == 1 {
+				// returning from the program ends it, with the value as
+				// the last popped one
+				return nil
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
@@ -183,6 +324,10 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				return invalidBytecode("OpReturn outside a function")
+			}
+
 			frame := vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
@@ -203,7 +348,7 @@ func (vm *VM) Run() error {
 
 			frame := vm.currentFrame()
 
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			err := vm.pushVariable(vm.stack[frame.basePointer+int(localIndex)])
 			if err != nil {
 				return err
 			}
@@ -211,9 +356,12 @@ func (vm *VM) Run() error {
 			builtinIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
-			definition := object.Builtins[builtinIndex]
+			builtin, ok := vm.builtins.At(int(builtinIndex))
+			if !ok {
+				return fmt.Errorf("unknown builtin %d", builtinIndex)
+			}
 
-			err := vm.push(definition.Builtin)
+			err := vm.push(builtin)
 			if err != nil {
 				return err
 			}
@@ -231,8 +379,128 @@ func (vm *VM) Run() error {
 			freeIndex := code.ReadUint8(ins[ip+1:])
 			vm.currentFrame().ip += 1
 
+			cell, err := vm.freeVariable(int(freeIndex))
+			if err != nil {
+				return err
+			}
+
+			err = vm.pushVariable(cell.Value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := vm.freeVariable(int(freeIndex))
+			if err != nil {
+				return err
+			}
+
+			cell.Value = vm.pop()
+		case code.OpGetFreeRef:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell, err := vm.freeVariable(int(freeIndex))
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(cell)
+			if err != nil {
+				return err
+			}
+		case code.OpGetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			cell := vm.localCell(int(localIndex))
+			err := vm.pushVariable(cell.Value)
+			if err != nil {
+				return err
+			}
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			value := vm.pop()
+
+			// a parameter is moved into a new cell on function entry
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = value
+			} else {
+				vm.stack[slot] = &object.Cell{Value: value}
+			}
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			// the handler needs room for the exception
+			if vm.sp >= StackSize {
+				return fmt.Errorf("stack overflow")
+			}
+
+			frame := vm.currentFrame()
+			frame.handlers = append(frame.handlers, handler{ip: pos, sp: vm.sp})
+		case code.OpEndTry:
+			frame := vm.currentFrame()
+			if len(frame.handlers) == 0 {
+				return invalidBytecode("OpEndTry without OpTry")
+			}
+
+			frame.handlers = frame.handlers[:len(frame.handlers)-1]
+		case code.OpThrow:
+			return vm.throw(vm.pop())
+		case code.OpCurrentClosure:
 			currentClosure := vm.currentFrame().cl
-			err := vm.push(currentClosure.Free[freeIndex])
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+		case code.OpGetCellRef:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			err := vm.push(vm.localCell(int(localIndex)))
+			if err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDupTwo:
+			first := vm.stack[vm.sp-2]
+			second := vm.stack[vm.sp-1]
+
+			err := vm.push(first)
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(second)
+			if err != nil {
+				return err
+			}
+		case code.OpIter:
+			iterator, err := newIterator(vm.pop())
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(iterator)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			err := vm.executeIterNext()
 			if err != nil {
 				return err
 			}
@@ -278,8 +546,10 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	rightType := right.Type()
 
 	switch {
-	case leftType == object.IntegerObj && rightType == object.IntegerObj:
+	case object.IsInteger(left) && object.IsInteger(right):
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, left, right)
 	case leftType == object.StringObj && rightType == object.StringObj:
 		return vm.executeBinaryStringOperation(op, left, right)
 	default:
@@ -288,10 +558,40 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 }
 
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	operator, ok := integerOperators[op]
+	if !ok {
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	if left.Type() == object.BigIntegerObj || right.Type() == object.BigIntegerObj {
+		err := vm.budget.Check()
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := object.IntegerInfix(operator, left, right)
+	if err != nil {
+		return err
+	}
 
-	var result int64
+	if result.Type() == object.BigIntegerObj {
+		err := vm.budget.Allocate(result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return vm.push(result)
+}
+
+// executeBinaryFloatOperation executes arithmetic between two numbers of
+// which at least one is a float.
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
+
+	var result float64
 
 	switch op {
 	case code.OpAdd:
@@ -303,10 +603,10 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpDiv:
 		result = leftValue / rightValue
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return fmt.Errorf("unknown float operator: %d", op)
 	}
 
-	return vm.push(&object.Integer{Value: result})
+	return vm.push(&object.Float{Value: result})
 }
 
 func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Object) error {
@@ -317,30 +617,62 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
-	return vm.push(&object.String{Value: leftValue + rightValue})
+	result := &object.String{Value: leftValue + rightValue}
+	err := vm.budget.Allocate(result)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.IntegerObj || right.Type() == object.IntegerObj {
+	if object.IsInteger(left) && object.IsInteger(right) {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, left, right)
+	}
+
+	if left.Type() == object.StringObj && right.Type() == object.StringObj &&
+		(op == code.OpGreaterThan || op == code.OpGreaterThanOrEqual) {
+		return vm.executeStringComparison(op, left, right)
+	}
+
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
+		return vm.push(nativeBoolToBooleanObject(object.Equal(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
+		return vm.push(nativeBoolToBooleanObject(!object.Equal(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d %s %s", op, left.Type(), right.Type())
 	}
 }
 
 func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
-	leftValue := left.(*object.Integer).Value
-	rightValue := right.(*object.Integer).Value
+	comparison := object.CompareIntegers(left, right)
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(comparison == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(comparison != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(comparison > 0))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(comparison >= 0))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := toFloat(left)
+	rightValue := toFloat(right)
 
 	switch op {
 	case code.OpEqual:
@@ -349,11 +681,28 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object
 		return vm.push(nativeBoolToBooleanObject(rightValue != leftValue))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
+// executeStringComparison orders strings lexicographically by their bytes.
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue >= rightValue))
+	default:
+		return fmt.Errorf("unknown string operator: %d", op)
+	}
+}
+
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
 
@@ -372,20 +721,40 @@ func (vm *VM) executeBangOperator() error {
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
 
-	if operand.Type() != object.IntegerObj {
+	switch operand := operand.(type) {
+	case *object.Integer, *object.BigInteger:
+		return vm.push(object.NegateInteger(operand))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
+}
+
+func (vm *VM) executeBitNotOperator() error {
+	operand := vm.pop()
+
+	if !object.IsInteger(operand) {
+		return fmt.Errorf("unsupported type for bitwise complement: %s", operand.Type())
+	}
 
-	value := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -value})
+	return vm.push(object.ComplementInteger(operand))
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
 		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.StringObj && index.Type() == object.IntegerObj:
+		return vm.executeStringIndex(left, index)
 	case left.Type() == object.HashObj:
 		return vm.executeHashIndex(left, index)
+	case left.Type() == object.ExceptionObj && index.Type() == object.StringObj:
+		field, ok := left.(*object.Exception).Field(index.(*object.String).Value)
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(field)
 	default:
 		return fmt.Errorf("index operator not supported: %s", left.Type())
 	}
@@ -403,20 +772,118 @@ func (vm *VM) executeArrayIndex(array, index object.Object) error {
 	return vm.push(arrayObject.Elements[i])
 }
 
+func (vm *VM) executeStringIndex(str, index object.Object) error {
+	runes := []rune(str.(*object.String).Value)
+	i := index.(*object.Integer).Value
+	max := int64(len(runes) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(&object.String{Value: string(runes[i])})
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
+	key, ok := object.AsHashable(index)
 	if !ok {
 		return fmt.Errorf("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	value, ok := hashObject.Get(key)
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+// executeIterNext advances the iterator on top of the stack. It pushes the
+// next value followed by True, or only False once the iterator is exhausted.
+func (vm *VM) executeIterNext() error {
+	iterator, ok := vm.pop().(*Iterator)
+	if !ok {
+		return fmt.Errorf("not an iterator")
+	}
+
+	element, ok := iterator.next()
+	if !ok {
+		return vm.push(False)
+	}
+
+	err := vm.push(element)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(True)
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ArrayObj && index.Type() == object.IntegerObj:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+
+		if i < 0 || i >= int64(len(elements)) {
+			return fmt.Errorf("index out of range: %d", i)
+		}
+
+		elements[i] = value
+		return nil
+	case left.Type() == object.HashObj:
+		key, ok := object.AsHashable(index)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+
+		left.(*object.Hash).Set(key, value)
+		return nil
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
+	}
+}
+
+// pushVariable pushes the value of a variable, which is nil when a function
+// refers to a variable that is declared later in its scope.
+func (vm *VM) pushVariable(o object.Object) error {
+	if o == nil {
+		return fmt.Errorf("variable used before definition")
+	}
+
+	return vm.push(o)
+}
+
+// freeVariable returns the cell of the free variable at index of the
+// current closure.
+func (vm *VM) freeVariable(index int) (*object.Cell, error) {
+	free := vm.currentFrame().cl.Free
+	if index >= len(free) {
+		return nil, invalidBytecode("free variable %d out of range", index)
+	}
+
+	cell, ok := free[index].(*object.Cell)
+	if !ok {
+		return nil, invalidBytecode("free variable %d is not a cell", index)
+	}
+
+	return cell, nil
+}
+
+// localCell returns the cell stored in a local slot of the current frame,
+// creating it if the variable has not been assigned yet.
+func (vm *VM) localCell(localIndex int) *object.Cell {
+	slot := vm.currentFrame().basePointer + localIndex
+
+	cell, ok := vm.stack[slot].(*object.Cell)
+	if !ok {
+		cell = &object.Cell{Value: vm.stack[slot]}
+		vm.stack[slot] = cell
+	}
+
+	return cell
 }
 
 func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
@@ -430,23 +897,21 @@ func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash((endIndex - startIndex) / 2)
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
+		hashKey, ok := object.AsHashable(key)
 		if !ok {
 			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
 		}
 
-		hashedPairs[hashKey.HashKey()] = pair
+		hash.Set(hashKey, value)
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) currentFrame() *Frame {
@@ -481,9 +946,25 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 			cl.Fn.NumParameters, numArgs)
 	}
 
-	frame := NewFrame(cl, vm.sp-numArgs)
+	basePointer := vm.sp - numArgs
+	if vm.framesIndex >= MaxFrames || basePointer+cl.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	err := vm.budget.Call(vm.framesIndex)
+	if err != nil {
+		return err
+	}
+
+	frame := NewFrame(cl, basePointer)
 	vm.pushFrame(frame)
 
+	// clear locals left over from earlier frames, so stale cells are not
+	// reused
+	for i := frame.basePointer + numArgs; i < frame.basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
@@ -492,16 +973,23 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	result := builtin.Fn(args...)
+	result := builtin.Fn(vm.context, args...)
 	vm.sp = vm.sp - numArgs - 1
 
-	if result != nil {
-		vm.push(result)
-	} else {
-		vm.push(Null)
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
 	}
 
-	return nil
+	if result == nil {
+		return vm.push(Null)
+	}
+
+	err := vm.budget.Allocate(result)
+	if err != nil {
+		return err
+	}
+
+	return vm.push(result)
 }
 
 func (vm *VM) pushClosure(constIndex, numFree int) error {
@@ -518,6 +1006,11 @@ func (vm *VM) pushClosure(constIndex, numFree int) error {
 	vm.sp = vm.sp - numFree
 
 	closure := &object.Closure{Fn: function, Free: free}
+	err := vm.budget.Allocate(closure)
+	if err != nil {
+		return err
+	}
+
 	return vm.push(closure)
 }
 
@@ -529,6 +1022,21 @@ func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return object.IsInteger(obj) || obj.Type() == object.FloatObj
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger:
+		return object.IntegerToFloat(obj)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj := obj.(type) {
 	case *object.Boolean:
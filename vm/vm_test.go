@@ -1,10 +1,16 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/kitasuke/monkey-go/ast"
+	"github.com/kitasuke/monkey-go/code"
 	"github.com/kitasuke/monkey-go/compiler"
 	"github.com/kitasuke/monkey-go/lexer"
 	"github.com/kitasuke/monkey-go/object"
@@ -35,6 +41,77 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"~-1", 0},
+		{"1 << 4", 16},
+		{"-16 >> 2", -4},
+		{"1 + 2 * 3 % 4", 3},
+		{"1 | 2 & 3", 3},
+		{"1 + 1 << 2", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"9223372036854775807 + 1", bigInteger("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInteger("-9223372036854775809")},
+		{"4294967296 * 4294967296", bigInteger("18446744073709551616")},
+		{"1 << 64", bigInteger("18446744073709551616")},
+		{"-(-9223372036854775807 - 1)", bigInteger("9223372036854775808")},
+		{"99999999999999999999", bigInteger("99999999999999999999")},
+		{"99999999999999999999 - 99999999999999999998", 1},
+		{"(1 << 64) / (1 << 60)", 16},
+		{"(1 << 64) % 7", 2},
+		{"(1 << 64) >> 64", 1},
+		{"~(1 << 64)", bigInteger("-18446744073709551617")},
+		{"(1 << 64) & 5", 0},
+		{"(1 << 64) | 1", bigInteger("18446744073709551617")},
+		{"(1 << 64) > 1", true},
+		{"1 >= (1 << 64)", false},
+		{"1 < (1 << 64)", true},
+		{"(1 << 64) == 18446744073709551616", true},
+		{"(1 << 64) != 1 << 64", false},
+		{"(1 << 64) == 18446744073709551616.0", true},
+		{"(1 << 63) * 0.5", 4611686018427387904.0},
+		{`
+		let factorial = fn(n) { if (n < 2) { 1 } else { n * factorial(n - 1) } };
+		factorial(25)
+		`, bigInteger("15511210043330985984000000")},
+		{"let h = {18446744073709551616: 1}; h[1 << 64]", 1},
+		{`json_decode("99999999999999999999") + 1`, bigInteger("100000000000000000000")},
+		{"int(1e20)", bigInteger("100000000000000000000")},
+		{"int(9223372036854775808.0)", bigInteger("9223372036854775808")},
+		{"int(-9223372036854775808.0)", -9223372036854775808},
+		{`int("0x10000000000000000")`, bigInteger("18446744073709551616")},
+		{"float(1 << 64)", 18446744073709551616.0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"10 - 2.5", 7.5},
+		{"7 / 2.0", 3.5},
+		{"1e3 / 4", 250.0},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"2.0 == 2", true},
+		{"0.1 + 0.2 != 0.3", true},
+		{"float(3)", 3.0},
+		{"int(3.99)", 3},
 	}
 
 	runVmTests(t, tests)
@@ -48,6 +125,19 @@ func TestBooleanExpressions(t *testing.T) {
 		{"1 > 2", false},
 		{"1 < 1", false},
 		{"1 > 1", false},
+		{"1 <= 2", true},
+		{"2 <= 1", false},
+		{"1 <= 1", true},
+		{"1 >= 2", false},
+		{"1 >= 1", true},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" > "B"`, true},
+		{`"abc" >= "abc"`, true},
+		{`"ab" <= "abc"`, true},
+		{`"" < "a"`, true},
 		{"1 == 1", true},
 		{"1 != 1", false},
 		{"1 == 2", false},
@@ -73,6 +163,33 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" + "b" == "ab"`, true},
+		{`"a" != "b"`, true},
+		{`"1" == 1`, false},
+		{`1 == 1.0`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`[] == {}`, false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{1: 1} == {"1": 1}`, false},
+		{`{2: 1} == {2.0: 1}`, true},
+		{`let n = if (false) { 1 }; n == n`, true},
+		{`let n = if (false) { 1 }; n == false`, false},
+		{`let n = if (false) { 1 }; n != 0`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`let a = [1]; a[0] = a; let b = [1]; b[0] = b; a == b`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -90,6 +207,134 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLogicalExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 0", true},
+		{"(if (false) { 1 }) || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3 || 3 > 2", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!true || true", true},
+		{"let n = 0; let f = fn() { n = n + 1; true }; false && f(); n", 0},
+		{"let n = 0; let f = fn() { n = n + 1; true }; true || f(); n", 0},
+		{"let n = 0; let f = fn() { n = n + 1; true }; true && f() && f(); n", 2},
+		{"let n = 0; let f = fn() { n = n + 1; false }; f() || f() || true; n", 2},
+		{"[1][5] || 7", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = 0; while (i < 5) { let sum = sum + i; let i = i + 1; }; sum", 10},
+		{"let i = 0; while (true) { if (i > 3) { break; } let i = i + 1; }; i", 4},
+		{"let i = 0; let n = 0; while (i < 10) { let i = i + 1; if (i > 5) { continue; } let n = n + 1; }; n", 5},
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let sum = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break; } let sum = sum + x; }; sum", 3},
+		{`let s = ""; for (c in "héllo") { let s = c + s; }; s`, "olléh"},
+		{"let sum = 0; for (k in {1: 10, 2: 20}) { let sum = sum + k; }; sum", 3},
+		{"let f = fn() { let i = 0; while (true) { let i = i + 1; if (i == 3) { return i * 10; } } }; f()", 30},
+		{"let f = fn(arr) { let sum = 0; for (x in arr) { let sum = sum + x; } sum }; f([4, 5])", 9},
+		{"let n = 0; for (a in [1, 2]) { for (b in [1, 2, 3]) { if (b == 2) { break; } let n = n + 1; } }; n", 2},
+		{"let i = 0; while (i < 100000) { let i = i + 1; }; i", 100000},
+		{"let i = 0; while (true) { i += 1; let y = if (true) { break; }; }; i", 1},
+		{"let i = 0; let n = 0; while (i < 5) { i += 1; n += 1 + if (i > 2) { continue; } else { 0 }; }; n", 2},
+		{"let i = 0; while (i < 3000) { i += 1; let y = 1 + if (true) { continue; } else { 0 }; }; i", 3000},
+		{"let i = 0; while (i < 3) { i += 1; let b = 1 < if (true) { continue; } else { 2 }; }; i", 3},
+		{"let n = 0; for (x in [1, 2, 3]) { len([x, if (x == 2) { continue; }]); n += x; }; n", 4},
+		{"let n = 0; for (x in [1, 2, 3]) { let h = {x: if (x == 3) { break; } else { x }}; n += h[x]; }; n", 3},
+		{"let a = [0]; for (x in [1, 2, 3]) { a[0] += if (x == 2) { continue; } else { x }; }; a[0]", 4},
+		{"let n = 0; for (x in [1, 2]) { let m = 10 + if (true) { let k = 0; while (true) { k += 1; if (k == x) { break; } }; k }; n += m; }; n", 23},
+		{"let n = 0; for (x in [1, 2]) { n += 1 + if (true) { try { continue; } finally { n += 100; } } else { 0 }; }; n", 200},
+		{"let f = fn() { let y = 1 + if (true) { return 5; }; 10 }; f()", 5},
+		{"let f = fn() { while (false) { 1 } }; f()", Null},
+		{"if (true) { while (false) { } }", Null},
+		{"if (true) { for (a in []) { } }", Null},
+		{"let f = fn() { for (a in [1]) { break; } }; f()", Null},
+		{"if (true) { } else { 1 }", Null},
+		{"if (true) { let x = 1; }", Null},
+		{"if (true) { }", Null},
+		{"fn() { }()", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a += 2; a;", 7},
+		{"let a = 5; a -= 2; a;", 3},
+		{"let a = 5; a *= 2; a;", 10},
+		{"let a = 6; a /= 2; a;", 3},
+		{"let i = 0; while (i < 5) { i += 1; }; i", 5},
+		{"let f = fn() { let i = 0; let sum = 0; while (i < 4) { i += 1; sum += i; } sum }; f()", 10},
+		{"let arr = [1, 2, 3]; arr[1] = 5; arr[1] + arr[0];", 6},
+		{"let arr = [1, 2, 3]; arr[2] *= 10; arr[2];", 30},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] += 5; h["a"] + h["b"];`, 8},
+		{"let count = 0; let inc = fn() { count += 1; }; inc(); inc(); count;", 2},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c(); c();", 3},
+		{"let f = fn(x) { let g = fn() { x = x * 2; }; g(); g(); x }; f(3)", 12},
+		{"let f = fn() { let a = 1; a = 2 }; f()", Null},
+		{"let f = fn() { let a = [1]; a[0] += 1 }; f()", Null},
+		{"let a = 1; if (true) { a = 2 }", Null},
+		{
+			`
+			let pair = fn() {
+				let n = 0;
+				let inc = fn() { n += 1; };
+				let get = fn() { fn() { n } };
+				[inc, get()]
+			};
+			let p = pair();
+			p[0](); p[0]();
+			p[1]()
+			`,
+			2,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let arr = [1]; arr[5] = 1;", "index out of range: 5"},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: String"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -155,7 +400,19 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[1]", 1},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
+		{"{2: 1}[2.0]", 1},
+		{"{2.0: 1}[2]", 1},
+		{"{2: 1}[2.5]", Null},
 		{"{}[0]", Null},
+		{"{[1, 2]: 3}[[1, 2]]", 3},
+		{"{[1, 2]: 3}[[2, 1]]", Null},
+		{`{[1, ["a"]]: 3}[[1, ["a"]]]`, 3},
+		{`{[1]: 3}[["1"]]`, Null},
+		{"let k = [1]; let h = {k: 1}; k[0] = 2; h[[1]]", 1},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[4]`, "o"},
+		{`"héllo"[5]`, Null},
+		{`"abc"[-1]`, Null},
 	}
 
 	runVmTests(t, tests)
@@ -222,6 +479,19 @@ func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"return 10;", 10},
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+		{"let i = 0; while (true) { i += 1; if (i == 3) { return i; } }", 3},
+		{"1 + if (true) { return 5; } else { 2 }", 5},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestFirstClassFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -411,51 +681,51 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: fmt.Sprintf("argument to %q not supported, got %s", object.BuiltinFuncNameLen, object.IntegerObj),
-			},
-		},
-		{
-			`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
+		{`len("h\u00e9llo")`, 5},
+		{`len("日本語")`, 3},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{
-			`first(1)`,
-			&object.Error{
-				Message: fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameFirst, object.ArrayObj, object.IntegerObj),
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{
-			`last(1)`,
-			&object.Error{
-				Message: fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameLast, object.ArrayObj, object.IntegerObj),
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{
-			`push(1, 1)`,
-			&object.Error{
-				Message: fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNamePush, object.ArrayObj, object.IntegerObj),
-			},
-		},
+		{`json_encode({"b": [1, 2.5], "a": true})`, `{"b":[1,2.5],"a":true}`},
+		{`json_decode("[1, 2, 3]")`, []int{1, 2, 3}},
+		{`json_decode(json_encode({"a": [1]}))["a"]`, []int{1}},
+		{`let a = [1]; json_encode([a, a, {"x": a}])`, `[[1],[1],{"x":[1]}]`},
+		{`keys({3: 1, 1: 2, 2: 3})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3})`, []int{1, 2, 3}},
+		{`entries({3: 1, 1: 2})[1]`, []int{1, 2}},
+		{`keys({})`, []int{}},
+		{`let h = {1: 1, 2: 2}; h[1] = 3; h[3] = 4; keys(h)`, []int{1, 2, 3}},
+		{`let h = {1: 1, 2: 2}; h[1] = 3; values(h)`, []int{3, 2}},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestBuiltinFunctionErrors(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`len(1)`, fmt.Sprintf("argument to %q not supported, got %s", object.BuiltinFuncNameLen, object.IntegerObj)},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameFirst, object.ArrayObj, object.IntegerObj)},
+		{`last(1)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameLast, object.ArrayObj, object.IntegerObj)},
+		{`push(1, 1)`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNamePush, object.ArrayObj, object.IntegerObj)},
+		{`json_decode("{")`, "json_decode: unexpected end of JSON input at offset 1"},
+		{`json_encode(fn() {})`, "json_encode: unsupported type Closure"},
+		{`let h = {}; h["self"] = h; json_encode(h)`, "json_encode: unsupported cyclic value"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; json_encode([h])`, "json_encode: unsupported cyclic value"},
+		{`keys([1])`, fmt.Sprintf("argument to %q must be %s, got %s", object.BuiltinFuncNameKeys, object.HashObj, object.ArrayObj)},
+		{`entries({}, {})`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -559,6 +829,383 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let wrapper = fn() {
+				let sum = fn(n) { if (n == 0) { 0 } else { n + sum(n - 1) } };
+				sum(4)
+			};
+			wrapper();
+			`,
+			10,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+				isEven(10) + isOdd(7)
+			};
+			wrapper();
+			`,
+			2,
+		},
+		{
+			`
+			let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+			isEven(3) + isOdd(3)
+			`,
+			1,
+		},
+		{
+			`
+			let f = fn() { 1 };
+			let g = fn() { let h = f(); let f = fn() { 2 }; h + f() };
+			g();
+			`,
+			3,
+		},
+		{
+			`
+			let isOdd = 5;
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+				isEven(4)
+			};
+			wrapper();
+			`,
+			1,
+		},
+		{
+			`
+			let n = len([1, 2]);
+			let len = fn(x) { 10 };
+			n + len([1])
+			`,
+			12,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let countDown = fn(n) {
+					let next = fn() { countDown(n - 1) };
+					if (n == 0) { 7 } else { next() }
+				};
+				countDown(3)
+			};
+			wrapper();
+			`,
+			7,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let f = fn(n) { if (n == 0) { 1 } else { f(n - 1) } };
+				let g = f;
+				f = fn(n) { 42 };
+				g(3)
+			};
+			wrapper();
+			`,
+			42,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestVariableUsedBeforeDefinition(t *testing.T) {
+	input := `
+	let wrapper = fn() {
+		let first = fn() { second() };
+		let result = first();
+		let second = fn() { 1 };
+		result
+	};
+	wrapper();
+	`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	expected := "variable used before definition"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestRunDecodedBytecode(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fibonacci = fn(x) { if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) } }; fibonacci(10)", 55},
+		{"let counter = fn() { let n = 0; fn() { n += 1; n } }; let c = counter(); c(); c()", 2},
+		{`let s = ""; for (c in ["a", "b"]) { s += c; }; s`, "ab"},
+		{"1.5 * 3", 4.5},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		data, err := comp.Bytecode().MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error: %s", err)
+		}
+
+		bytecode := &compiler.Bytecode{}
+		err = bytecode.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatalf("UnmarshalBinary error: %s", err)
+		}
+
+		vm := New(bytecode)
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestRunInvalidBytecode(t *testing.T) {
+	getFree := &object.CompiledFunction{
+		Instructions: append(code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue)...),
+	}
+
+	tests := []struct {
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpAdd)},
+			"invalid bytecode: offset 0: stack underflow in OpAdd",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpCall, 0)},
+			"invalid bytecode: offset 0: stack underflow in OpCall",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpEndTry)},
+			"invalid bytecode: offset 0: OpEndTry without OpTry",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpGetFree, 0)},
+			"invalid bytecode: free variable 0 out of range",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: append(append(code.Make(code.OpTrue),
+					code.Make(code.OpClosure, 0, 1)...),
+					code.Make(code.OpCall, 0)...),
+				Constants: []object.Object{getFree},
+			},
+			"invalid bytecode: free variable 0 is not a cell",
+		},
+	}
+
+	for _, tt := range tests {
+		err := New(tt.bytecode).Run()
+		if !errors.Is(err, ErrInvalidBytecode) {
+			t.Errorf("error for %s is not ErrInvalidBytecode. got=%T (%+v)", tt.bytecode.Instructions, err, err)
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestBuiltinPanics(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	builtins.Register("fail", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		var elements []object.Object
+		return elements[len(args)]
+	}})
+
+	comp := compiler.NewWithBuiltins(builtins)
+	err := comp.Compile(parse("fail()"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetBuiltins(builtins)
+
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Errorf("the panic of the builtin did not propagate")
+		}
+	}()
+
+	vm.Run()
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let apply = fn(f) {
+  fn() {
+    f(1, true)
+  }()
+};
+apply(add);`
+
+	program := parser.New(lexer.NewWithFilename(input, "script.mk")).ParseProgram()
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not %T. got=%T (%v)", &RuntimeError{}, err, err)
+	}
+
+	expectedMessage := "unsupported types for binary operation: Integer Boolean"
+	if runtimeErr.Message != expectedMessage {
+		t.Errorf("wrong message. want=%q, got=%q", expectedMessage, runtimeErr.Message)
+	}
+
+	expectedTrace := `	at add (script.mk:2:3)
+	at <anonymous> (script.mk:6:5)
+	at apply (script.mk:5:3)
+	at <main> (script.mk:9:1)
+`
+	if runtimeErr.StackTrace() != expectedTrace {
+		t.Errorf("wrong stack trace.\nwant=%q\ngot=%q", expectedTrace, runtimeErr.StackTrace())
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := `let f = fn(x) { f(x + 1) + 1 }; f(0);`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	if err.Error() != "stack overflow" {
+		t.Fatalf("wrong VM error: want=%q, got=%q", "stack overflow", err.Error())
+	}
+
+	runtimeErr := err.(*RuntimeError)
+	if len(runtimeErr.Stack) != MaxFrames {
+		t.Errorf("wrong stack depth. want=%d, got=%d", MaxFrames, len(runtimeErr.Stack))
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = 0; try { r = 1; } catch (e) { r = 2; }; r`, 1},
+		{`let r = ""; try { throw "boom"; r = "unreachable"; } catch (e) { r = e["message"]; }; r`, "boom"},
+		{`let r = 0; try { throw {"a": 7}; } catch (e) { r = e["value"]["a"]; }; r`, 7},
+		{`let r = ""; try { len(1); } catch (e) { r = e["message"]; }; r`, `argument to "len" not supported, got Integer`},
+		{`let r = ""; try { [1][0] + true; } catch (e) { r = e["message"]; }; r`, "unsupported types for binary operation: Integer Boolean"},
+		{`let r = ""; try { 1 / 0; } catch (e) { r = e["message"]; }; r`, "division by zero"},
+		{`let r = ""; try { 1 % 0; } catch (e) { r = e["message"]; }; r`, "division by zero"},
+		{`let r = ""; try { 1 << -1; } catch (e) { r = e["message"]; }; r`, "negative shift count: -1"},
+		{`let r = ""; try { 1 << 9999999; } catch (e) { r = e["message"]; }; r`, "shift count too large: 9999999"},
+		{`let r = ""; try { (1 << 1000000) * (1 << 1000000); } catch (e) { r = e["message"]; }; r`, "integer too large: more than 1048576 bits"},
+		{`let r = ""; try { ~true; } catch (e) { r = e["message"]; }; r`, "unsupported type for bitwise complement: Boolean"},
+		{`let r = ""; try { 1(); } catch (e) { r = e["message"]; }; r`, "calling non-function and non-built-in"},
+		{`let r = []; try { throw 5; } catch (e) { r = push(r, e["value"]); } finally { r = push(r, 6); }; r`, []int{5, 6}},
+		{`let r = []; let f = fn() { try { return 1; } finally { r = push(r, 2); } }; let v = f(); push(r, v)`, []int{2, 1}},
+		{`let f = fn() { try { throw "a"; } finally { return 2; } }; f()`, 2},
+		{`let f = fn() { try { return 1; } finally { return 2; } }; f()`, 2},
+		{`let r = []; let i = 0; while (i < 3) { i += 1; try { if (i == 2) { continue; } r = push(r, i); } finally { r = push(r, 0); } }; r`, []int{1, 0, 0, 3, 0}},
+		{`let r = []; for (x in [1, 2, 3]) { try { if (x == 2) { break; } r = push(r, x); } finally { r = push(r, 0); } }; r`, []int{1, 0, 0}},
+		{`let r = ""; try { try { throw "inner"; } catch (e) { throw e; } } catch (e) { r = e["message"]; }; r`, "inner"},
+		{`let r = 0; try { try { throw "inner"; } finally { r = 1; } } catch (e) { r = r + 10; }; r`, 11},
+		{`let g = fn(x) { if (x == 0) { throw "bottom"; } g(x - 1) + 1 }; let r = 0; try { g(3) } catch (e) { r = len(e["stack"]); }; r`, 5},
+		{`let f = fn() { try { throw "x"; } catch (e) { return fn() { e["message"] }; } }; f()()`, "x"},
+		{`let x = 1; try { x = x + 1; throw x; } catch (err) { x = x + err["value"]; } finally { x = x * 10; }; x`, 40},
+		{`let f = fn(x) { f(x + 1) }; let r = ""; try { f(0) } catch (e) { r = e["message"]; }; r`, "stack overflow"},
+		{`let r = 0; try { throw 1; } catch (e) { r = e["missing"]; }; r`, Null},
+		{`let x = if (true) { try { 1 } catch (e) { 2 } }; x`, Null},
+		{`let x = if (false) { 1 } else { try { throw 1 } catch (e) { 2 } }; x`, Null},
+		{`let f = fn() { try { 1 } finally { 2 } }; f()`, Null},
+		{`let r = 0; if (true) { try { throw 1 } catch (e) { r = 3 } }; r`, 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	tests := []vmErrorTestCase{
+		{`throw "oops";`, "oops"},
+		{`throw 42;`, "42"},
+		{`let f = fn() { try { throw "a"; } catch (e) { throw "b"; } }; f();`, "b"},
+		{`try { throw "a"; } finally { 1; }`, "a"},
+	}
+
+	runVmErrorTests(t, tests)
+}
+
+type vmErrorTestCase struct {
+	input    string
+	expected string
+}
+
+func runVmErrorTests(t *testing.T, tests []vmErrorTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
@@ -592,6 +1239,20 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case *big.Int:
+		result, ok := actual.(*object.BigInteger)
+		if !ok {
+			t.Errorf("object is not BigInteger. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value.Cmp(expected) != 0 {
+			t.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -627,13 +1288,13 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
 				t.Errorf("no pair for given key in pairs")
 			}
@@ -673,6 +1334,20 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -705,3 +1380,140 @@ func parse(input string) *ast.Program {
 	p := parser.New(l)
 	return p.ParseProgram()
 }
+
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{`while (true) { }`, object.Limits{MaxInstructions: 1000}, "instruction limit exceeded"},
+		{`let i = 0; while (true) { try { i += 1; } catch (e) { } }`, object.Limits{MaxInstructions: 1000}, "instruction limit exceeded"},
+		{`let f = fn(n) { f(n + 1) }; f(0)`, object.Limits{MaxCallDepth: 100}, "call depth limit exceeded"},
+		{`let f = fn(n) { f(n + 1) }; try { f(0) } catch (e) { 1 }`, object.Limits{MaxCallDepth: 100}, "call depth limit exceeded"},
+		{`let a = []; while (true) { a = push(a, 1) }`, object.Limits{MaxAllocations: 10000}, "allocation limit exceeded"},
+		{`let s = ""; while (true) { s = s + "x" }`, object.Limits{MaxAllocations: 100}, "allocation limit exceeded"},
+		{`while (true) { }`, object.Limits{Timeout: time.Millisecond}, "time limit exceeded"},
+		{`let x = 3; while (true) { x = x * x }`, object.Limits{MaxAllocations: 1000}, "allocation limit exceeded"},
+		{`let x = 3; while (true) { x *= x }`, object.Limits{MaxAllocations: 1000}, "allocation limit exceeded"},
+		{`let x = (1 << 500000) + 1; while (true) { x = x * x / x }`, object.Limits{Timeout: time.Millisecond}, "time limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if !errors.Is(err, object.ErrBudgetExceeded) {
+			t.Errorf("error is not ErrBudgetExceeded. got=%T (%+v)", err, err)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	program := parse(`while (true) { }`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	vm := New(comp.Bytecode())
+	err = vm.RunContext(ctx)
+
+	if !errors.Is(err, object.ErrBudgetExceeded) {
+		t.Errorf("error is not ErrBudgetExceeded. got=%T (%+v)", err, err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error is not context.DeadlineExceeded. got=%T (%+v)", err, err)
+	}
+}
+
+func TestRunWithinBudget(t *testing.T) {
+	program := parse(`let f = fn(n) { if (n == 0) { return [] } push(f(n - 1), n) }; f(10)`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetLimits(object.Limits{MaxInstructions: 1000, MaxCallDepth: 11, MaxAllocations: 100})
+
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, vm.LastPoppedStackElem())
+}
+
+func TestCustomBuiltins(t *testing.T) {
+	builtins := object.NewBuiltinRegistry()
+	builtins.Register("double", &object.Builtin{Fn: func(ctx *object.BuiltinContext, args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	}})
+	length, _ := object.DefaultBuiltins().Lookup("len")
+	builtins.Register("len", length)
+
+	program := parse(`double(len("abc"))`)
+
+	comp := compiler.NewWithBuiltins(builtins)
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	vm.SetBuiltins(builtins)
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	testExpectedObject(t, 6, vm.LastPoppedStackElem())
+
+	// removing a builtin breaks code compiled with it
+	builtins.Remove("double")
+
+	vm = New(comp.Bytecode())
+	vm.SetBuiltins(builtins)
+	err = vm.Run()
+	if err == nil || err.Error() != "unknown builtin 0" {
+		t.Errorf("wrong VM error: want=%q, got=%v", "unknown builtin 0", err)
+	}
+
+	err = compiler.NewWithBuiltins(builtins).Compile(program)
+	if err == nil || err.Error() != "1:1: undefined variable double" {
+		t.Errorf("wrong compiler error: want=%q, got=%v", "1:1: undefined variable double", err)
+	}
+}
+
+func bigInteger(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic("invalid integer " + s)
+	}
+	return n
+}